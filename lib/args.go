@@ -0,0 +1,79 @@
+package main
+
+// This file is shared by the load actions; their .include pulls it into the build.
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// intArg returns the named integer arg, which may be a JSON number or a query string, or def if
+// it isn't set.
+func intArg(args map[string]interface{}, name string, def int) (int, error) {
+	i, err := int64Arg(args, name, int64(def))
+	return int(i), err
+}
+
+func int64Arg(args map[string]interface{}, name string, def int64) (int64, error) {
+	switch v := args[name].(type) {
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("%s must be an integer, got %v", name, v)
+		}
+		return int64(v), nil
+	case string:
+		if v == "" {
+			return def, nil
+		}
+		i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse %s parameter: %w", name, err)
+		}
+		return i, nil
+	}
+	return def, nil
+}
+
+// floatArg returns the named numeric arg, which may be a JSON number or a query string, or def if
+// it isn't set.
+func floatArg(args map[string]interface{}, name string, def float64) (float64, error) {
+	switch v := args[name].(type) {
+	case float64:
+		return v, nil
+	case string:
+		if v == "" {
+			return def, nil
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse %s parameter: %w", name, err)
+		}
+		return f, nil
+	}
+	return def, nil
+}
+
+// argSet reports whether the named arg is set, as a JSON number or a non-empty query string.
+func argSet(args map[string]interface{}, name string) bool {
+	switch v := args[name].(type) {
+	case float64:
+		return true
+	case string:
+		return v != ""
+	}
+	return false
+}
+
+// boolArg reports whether the named arg is set to true, either as a JSON bool or a query string.
+func boolArg(args map[string]interface{}, name string) bool {
+	switch v := args[name].(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+package main
+
+// This file is shared by the load actions; their .include pulls it into the build.
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// platformDeadline returns the time the platform will kill this invocation, if it provides one
+// (__OW_DEADLINE, milliseconds since the epoch).
+func platformDeadline() (time.Time, bool) {
+	if ms, err := strconv.ParseInt(os.Getenv("__OW_DEADLINE"), 10, 64); err == nil && ms > 0 {
+		return time.UnixMilli(ms), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,137 @@
+package main
+
+// This file is shared by the load actions; their .include pulls it into the build.
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Fault kinds, selected by the `fault` arg.
+const (
+	// faultStatus responds with `fault_status`, default 500.
+	faultStatus = "status"
+	// faultPanic panics, which the platform reports as an action error.
+	faultPanic = "panic"
+	// faultExit exits the process, killing the instance.
+	faultExit = "exit"
+	// faultHang sleeps past the platform's deadline, so the platform times the invocation out.
+	faultHang = "hang"
+	// faultMalformed responds 200 with a truncated JSON body.
+	faultMalformed = "malformed"
+)
+
+// faultHangFallback is how long faultHang sleeps if the platform hasn't given us a deadline.
+const faultHangFallback = time.Hour
+
+// faultCalls counts the calls to fault.due in this process, for `fault_every`.
+var faultCalls int64
+
+// fault is a fault to inject, read from args by parseFault.
+type fault struct {
+	kind   string
+	status int
+	// rate is the probability of each call failing, if every is 0.
+	rate float64
+	// every fails every Nth call of the process, if it's not 0.
+	every int64
+}
+
+// parseFault reads the fault injection args, returning nil if `fault` isn't set. Calls fail with
+// probability `fault_rate` (0 to 1) or on every `fault_every`th call of the instance; with neither,
+// every call fails.
+func parseFault(args map[string]interface{}) (*fault, error) {
+	kind, _ := args["fault"].(string)
+	if kind == "" {
+		return nil, nil
+	}
+	f := &fault{kind: kind, status: http.StatusInternalServerError, rate: 1}
+	switch kind {
+	case faultStatus, faultPanic, faultExit, faultHang, faultMalformed:
+	default:
+		return nil, fmt.Errorf("unknown fault %q", kind)
+	}
+
+	status, err := intArg(args, "fault_status", http.StatusInternalServerError)
+	if err != nil {
+		return nil, err
+	}
+	if status < 400 || status > 599 {
+		return nil, fmt.Errorf("fault_status must be a 4xx or 5xx status, got %d", status)
+	}
+	f.status = status
+	hasRate, hasEvery := argSet(args, "fault_rate"), argSet(args, "fault_every")
+	switch {
+	case hasRate && hasEvery:
+		return nil, errors.New("fault_rate and fault_every are mutually exclusive")
+	case hasRate:
+		if f.rate, err = floatArg(args, "fault_rate", 1); err != nil {
+			return nil, err
+		}
+		if !(f.rate >= 0 && f.rate <= 1) {
+			return nil, fmt.Errorf("fault_rate must be between 0 and 1, got %v", f.rate)
+		}
+	case hasEvery:
+		if f.every, err = int64Arg(args, "fault_every", 0); err != nil {
+			return nil, err
+		}
+		if f.every < 1 {
+			return nil, fmt.Errorf("fault_every must be a positive integer, got %d", f.every)
+		}
+	}
+	return f, nil
+}
+
+// due reports whether this call should fail.
+func (f *fault) due() bool {
+	n := atomic.AddInt64(&faultCalls, 1)
+	if f.every > 0 {
+		return n%f.every == 0
+	}
+	return faultRand() < f.rate
+}
+
+// inject logs and applies the fault. It only returns for faults which are responses.
+func (f *fault) inject(args map[string]interface{}) map[string]interface{} {
+	fmt.Printf("fault: injecting %s\n", f.kind)
+	switch f.kind {
+	case faultPanic:
+		panic("injected fault: panic")
+	case faultExit:
+		os.Exit(1)
+	case faultHang:
+		d := faultHangFallback
+		if deadline, ok := platformDeadline(); ok {
+			d = time.Until(deadline) + time.Minute
+		}
+		time.Sleep(d)
+		// We've outlived the deadline, so fall back to failing with a status.
+	case faultMalformed:
+		return map[string]interface{}{
+			"statusCode": http.StatusOK,
+			"headers": map[string]interface{}{
+				"Content-Type":     "application/json",
+				"X-Injected-Fault": f.kind,
+			},
+			"body": `{"injected_fault": "malformed", "trunc`,
+		}
+	}
+	out := respondErr(args, f.status, fmt.Sprintf("injected fault: status %d", f.status), "injected_fault")
+	out["headers"].(map[string]interface{})["X-Injected-Fault"] = f.kind
+	return out
+}
+
+// faultRand returns a random float in [0, 1).
+func faultRand() float64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return float64(time.Now().UnixNano()%1000) / 1000
+	}
+	return float64(binary.LittleEndian.Uint64(b[:])>>11) / (1 << 53)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestInjectStatus(t *testing.T) {
+	f, err := parseFault(map[string]interface{}{"fault": faultStatus, "fault_status": "503"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := f.inject(map[string]interface{}{"format": "json"})
+	if out["statusCode"] != http.StatusServiceUnavailable {
+		t.Errorf("status = %v, want 503", out["statusCode"])
+	}
+	if got := out["headers"].(map[string]interface{})["X-Injected-Fault"]; got != faultStatus {
+		t.Errorf("X-Injected-Fault = %v, want %s", got, faultStatus)
+	}
+	body := out["body"].(map[string]interface{})
+	if body["error"] != "injected fault: status 503" || body["code"] != "injected_fault" {
+		t.Errorf("body = %v", body)
+	}
+}
+
+func TestParseFault(t *testing.T) {
+	for _, tc := range []struct {
+		args map[string]interface{}
+		want *fault
+	}{
+		{args: map[string]interface{}{}},
+		{
+			args: map[string]interface{}{"fault": faultPanic},
+			want: &fault{kind: faultPanic, status: http.StatusInternalServerError, rate: 1},
+		},
+		{
+			args: map[string]interface{}{"fault": faultStatus, "fault_status": 429.0, "fault_rate": "0.25"},
+			want: &fault{kind: faultStatus, status: http.StatusTooManyRequests, rate: 0.25},
+		},
+		{
+			args: map[string]interface{}{"fault": faultExit, "fault_every": "3"},
+			want: &fault{kind: faultExit, status: http.StatusInternalServerError, rate: 1, every: 3},
+		},
+		{args: map[string]interface{}{"fault": "boom"}},
+		{args: map[string]interface{}{"fault": faultStatus, "fault_status": "200"}},
+		{args: map[string]interface{}{"fault": faultStatus, "fault_status": 503.5}},
+		{args: map[string]interface{}{"fault": faultStatus, "fault_rate": "1.5"}},
+		{args: map[string]interface{}{"fault": faultStatus, "fault_rate": "NaN"}},
+		{args: map[string]interface{}{"fault": faultStatus, "fault_every": "0"}},
+		{args: map[string]interface{}{"fault": faultStatus, "fault_every": 2.5}},
+		{args: map[string]interface{}{"fault": faultStatus, "fault_rate": "0.5", "fault_every": "2"}},
+	} {
+		got, err := parseFault(tc.args)
+		wantErr := tc.want == nil && tc.args["fault"] != nil
+		if (err != nil) != wantErr {
+			t.Errorf("%v: err = %v, want an error: %t", tc.args, err, wantErr)
+			continue
+		}
+		if tc.want != nil && (got == nil || *got != *tc.want) {
+			t.Errorf("%v: got %+v, want %+v", tc.args, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+// This file is shared by every action; each action's .include pulls it into the build.
+
+import (
+	"html"
+	"strings"
+)
+
+// Response formats.
+const (
+	formatHTML = "html"
+	formatJSON = "json"
+)
+
+// responseFormat picks the response format from the `format` arg, falling back to the request's
+// Accept header. HTML is the default so the actions stay browsable.
+func responseFormat(args map[string]interface{}) string {
+	if f, _ := args["format"].(string); f != "" {
+		if strings.EqualFold(f, formatJSON) {
+			return formatJSON
+		}
+		return formatHTML
+	}
+	headers, _ := args["__ow_headers"].(map[string]interface{})
+	accept, _ := headers["accept"].(string)
+	if strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html") {
+		return formatJSON
+	}
+	return formatHTML
+}
+
+// respond builds a web action response. Clients which asked for JSON receive data as the body,
+// everyone else receives text, escaped and wrapped in a <pre>. Lines are separated by "\n".
+func respond(args map[string]interface{}, status int, text string, data interface{}) map[string]interface{} {
+	return respondHTML(args, status, html.EscapeString(text), data)
+}
+
+// respondHTML is respond for a body which is already HTML. Every value in it which came from the
+// request, a driver or anywhere else outside the action must be escaped with html.EscapeString.
+func respondHTML(args map[string]interface{}, status int, body string, data interface{}) map[string]interface{} {
+	if responseFormat(args) == formatJSON {
+		return map[string]interface{}{
+			"statusCode": status,
+			"headers":    map[string]interface{}{"Content-Type": "application/json"},
+			"body":       data,
+		}
+	}
+	return map[string]interface{}{
+		"statusCode": status,
+		"headers":    map[string]interface{}{"Content-Type": "text/html; charset=utf-8"},
+		"body":       wrapHTML(body),
+	}
+}
+
+// respondErr builds an error response; a red message for browsers or a JSON error object. code is
+// an optional machine-readable error code, e.g. a Postgres SQLSTATE.
+func respondErr(args map[string]interface{}, status int, msg, code string) map[string]interface{} {
+	data := map[string]interface{}{
+		"error":  msg,
+		"status": status,
+	}
+	text := msg
+	if code != "" {
+		data["code"] = code
+		text += " (" + code + ")"
+	}
+	return respondHTML(args, status, `<span style="color: red;">`+html.EscapeString(text)+"</span>", data)
+}
+
+func wrapHTML(body string) string {
+	return "<html><body><pre>" + body + "</pre></body></html>"
+}
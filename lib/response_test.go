@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRespondEscapes(t *testing.T) {
+	const payload = `"bogus<script>alert(1)</script>&`
+	for name, out := range map[string]map[string]interface{}{
+		"respond":    respond(map[string]interface{}{}, 200, "mode="+payload+"\nnext", nil),
+		"respondErr": respondErr(map[string]interface{}{}, 400, "unknown mode "+payload, "<code>"),
+	} {
+		body := out["body"].(string)
+		if strings.Contains(body, "<script>") || strings.Contains(body, "<code>") {
+			t.Errorf("%s didn't escape the request's values: %s", name, body)
+		}
+		if !strings.Contains(body, "&lt;script&gt;") {
+			t.Errorf("%s lost the escaped value: %s", name, body)
+		}
+	}
+	out := respondErr(map[string]interface{}{"format": "json"}, 400, payload, "")
+	if got := out["body"].(map[string]interface{})["error"]; got != payload {
+		t.Errorf("JSON error = %q, want it unescaped", got)
+	}
+}
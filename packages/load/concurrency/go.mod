@@ -3,6 +3,14 @@ module github.com/jcodybaker/functions-load/packages/load/concurrency
 go 1.17
 
 require (
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/go-sql-driver/mysql v1.7.1
 	github.com/lib/pq v1.10.4
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/xo/dburl v0.8.5
 )
+
+require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+)
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// instanceID identifies this process. The platform starts a new process for each cold start and
+// reuses it for warm invocations, so a fresh instanceID is a fresh container.
+var instanceID = randomID(8)
+
+var (
+	// seqMu guards instanceSeq, and is held across the inc which logs the next number.
+	seqMu sync.Mutex
+	// instanceSeq counts the invocations this process has counted. Rejected calls, calls whose
+	// inc fails and the read-only modes don't take a number, so they can't hide the process's cold
+	// start from the stats.
+	instanceSeq int64
+)
+
+// countInvocation numbers inv within the process and calls inc, which logs it with that number.
+// The number is only taken once inc succeeds. The one with seq 1 is taken as the process's cold
+// start. The platform sends a process one activation at a time, so holding seqMu across inc
+// doesn't hold anything up.
+func countInvocation(ctx context.Context, st store, inv *invocation) (active, peak, total int, err error) {
+	seqMu.Lock()
+	defer seqMu.Unlock()
+	inv.seq = instanceSeq + 1
+	if active, peak, total, err = st.inc(ctx, inv); err != nil {
+		inv.seq = 0
+		return 0, 0, 0, err
+	}
+	instanceSeq = inv.seq
+	return active, peak, total, nil
+}
+
+// instanceStats summarizes the processes which have served a test.
+type instanceStats struct {
+	invocations int
+	instances   int
+	coldStarts  int
+}
+
+// reuseRatio is the fraction of invocations which were served by a warm instance.
+func (s instanceStats) reuseRatio() float64 {
+	if s.invocations == 0 {
+		return 0
+	}
+	return float64(s.invocations-s.coldStarts) / float64(s.invocations)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	outcomeOK    = "ok"
+	outcomeError = "error"
+	// outcomeTimeout means the wait was cut short to leave time for dec() before the deadline.
+	outcomeTimeout = "timeout"
+	// outcomeFault means a fault was injected with the `fault` args.
+	outcomeFault = "fault"
+)
+
+// maxSeriesPoints bounds the size of a series response. The step is widened if a test ran long
+// enough to exceed it.
+const maxSeriesPoints = 10000
+
+// invocation describes a single call to Main. Its id is used both as the lease ID and as the
+// activation ID in the invocation log.
+type invocation struct {
+	id       string
+	testName string
+	wait     time.Duration
+	expires  time.Time
+	instance string
+	seq      int64
+	// runID is the test's run the invocation belongs to. It's set by inc().
+	runID int
+	// shard is the counter shard, from 1, which inc() bumps instead of the test's counter row, or
+	// 0 if sharding is off.
+	shard int
+}
+
+// interval is the span of time an invocation was active.
+type interval struct {
+	start, end time.Time
+}
+
+// point is a sample of a concurrency series.
+type point struct {
+	at     time.Time
+	active int
+}
+
+// invocationRecord is an invocation as logged by the store.
+type invocationRecord struct {
+	started time.Time
+	// ended is zero if the invocation never logged an end.
+	ended   time.Time
+	expires time.Time
+	outcome string
+}
+
+// intervals returns the span of each invocation. Invocations which never logged an end are
+// assumed to have run until their lease expired, or until now if it hasn't yet.
+func intervals(recs []invocationRecord, now time.Time) []interval {
+	out := make([]interval, 0, len(recs))
+	for _, rec := range recs {
+		end := rec.ended
+		if end.IsZero() {
+			end = rec.expires
+			if now.Before(end) {
+				end = now
+			}
+		}
+		out = append(out, interval{start: rec.started, end: end})
+	}
+	return out
+}
+
+// concurrencySeries samples the number of active invocations every step, from the first start
+// until the last end. An invocation is active at t if it started at or before t and ended after it.
+func concurrencySeries(ivs []interval, step time.Duration) []point {
+	if len(ivs) == 0 || step <= 0 {
+		return nil
+	}
+	first, last := ivs[0].start, ivs[0].end
+	starts := make([]time.Time, 0, len(ivs))
+	ends := make([]time.Time, 0, len(ivs))
+	for _, iv := range ivs {
+		if iv.start.Before(first) {
+			first = iv.start
+		}
+		if iv.end.After(last) {
+			last = iv.end
+		}
+		starts = append(starts, iv.start)
+		ends = append(ends, iv.end)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	sort.Slice(ends, func(i, j int) bool { return ends[i].Before(ends[j]) })
+
+	if n := last.Sub(first)/step + 1; n > maxSeriesPoints {
+		step = last.Sub(first)/(maxSeriesPoints-1) + 1
+	}
+
+	var out []point
+	var s, e int
+	for t := first.Truncate(step); !t.After(last); t = t.Add(step) {
+		for s < len(starts) && !starts[s].After(t) {
+			s++
+		}
+		for e < len(ends) && !ends[e].After(t) {
+			e++
+		}
+		out = append(out, point{at: t, active: s - e})
+	}
+	return out
+}
+
+// peakConcurrency sweeps over the start and end of every interval, returning the most that were
+// active at once and when that was first reached. Ends sort before starts at the same instant,
+// matching concurrencySeries, so back-to-back invocations aren't counted as overlapping.
+func peakConcurrency(ivs []interval) (peak int, at time.Time) {
+	type event struct {
+		at    time.Time
+		delta int
+	}
+	events := make([]event, 0, 2*len(ivs))
+	for _, iv := range ivs {
+		events = append(events, event{iv.start, 1}, event{iv.end, -1})
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].at.Equal(events[j].at) {
+			return events[i].at.Before(events[j].at)
+		}
+		return events[i].delta < events[j].delta
+	})
+	var active int
+	for _, e := range events {
+		active += e.delta
+		if active > peak {
+			peak, at = active, e.at
+		}
+	}
+	return peak, at
+}
+
+// series responds with the concurrency over time of the run selected by the `run` arg (default
+// the current run), sampled every `step` (default 1s).
+func series(ctx context.Context, st store, testName string, args map[string]interface{}) map[string]interface{} {
+	step := time.Second
+	if stepString, _ := args["step"].(string); stepString != "" {
+		var err error
+		if step, err = time.ParseDuration(stepString); err != nil {
+			return wrapErr(args, http.StatusBadRequest, err, "parsing step")
+		}
+		if step <= 0 {
+			return wrapErr(args, http.StatusBadRequest, fmt.Errorf("step must be positive, got %s", step), "parsing step")
+		}
+	}
+
+	runID, err := resolveRun(ctx, st, testName, args, "run")
+	if err != nil {
+		return wrapRunErr(args, err)
+	}
+	recs, err := st.invocations(ctx, testName, runID)
+	if err != nil {
+		return wrapErr(args, http.StatusInternalServerError, err, "building series")
+	}
+	ivs := intervals(recs, time.Now())
+	var b strings.Builder
+	points := []map[string]interface{}{}
+	for _, p := range concurrencySeries(ivs, step) {
+		at := p.at.UTC().Format(time.RFC3339Nano)
+		fmt.Fprintf(&b, "%s active=%d\n", at, p.active)
+		points = append(points, map[string]interface{}{"at": at, "active": p.active})
+	}
+	return respond(args, http.StatusOK, b.String(), map[string]interface{}{
+		"test_name": testName,
+		"run":       runID,
+		"step":      step.String(),
+		"points":    points,
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// at returns a time s seconds after a fixed epoch, which is a multiple of every step used here.
+func at(s float64) time.Time {
+	return time.Unix(1700000000, 0).UTC().Add(time.Duration(s * float64(time.Second)))
+}
+
+func TestConcurrencySeries(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ivs  []interval
+		step time.Duration
+		// want is the active count at each step from the first start.
+		want []int
+	}{
+		{name: "empty", step: time.Second},
+		{
+			name: "one",
+			ivs:  []interval{{at(0), at(2)}},
+			step: time.Second,
+			want: []int{1, 1, 0},
+		},
+		{
+			name: "overlapping",
+			ivs:  []interval{{at(0), at(3)}, {at(1), at(4)}, {at(1.5), at(2.5)}},
+			step: time.Second,
+			want: []int{1, 2, 3, 1, 0},
+		},
+		{
+			// The second starts as the first ends, so they're never both active.
+			name: "back to back",
+			ivs:  []interval{{at(1), at(2)}, {at(0), at(1)}},
+			step: time.Second,
+			want: []int{1, 1, 0},
+		},
+		{
+			name: "between samples",
+			ivs:  []interval{{at(0), at(1)}, {at(0.2), at(0.7)}},
+			step: time.Second,
+			want: []int{1, 0},
+		},
+		{
+			// The first start is rounded down to a step.
+			name: "unaligned",
+			ivs:  []interval{{at(0.5), at(2.5)}},
+			step: time.Second,
+			want: []int{0, 1, 1},
+		},
+		{
+			name: "wide step",
+			ivs:  []interval{{at(0), at(10)}, {at(4), at(6)}},
+			step: 5 * time.Second,
+			want: []int{1, 2, 0},
+		},
+		{name: "no step", ivs: []interval{{at(0), at(1)}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			points := concurrencySeries(tc.ivs, tc.step)
+			var got []int
+			for i, p := range points {
+				if want := points[0].at.Add(time.Duration(i) * tc.step); !p.at.Equal(want) {
+					t.Errorf("point %d is at %s, want %s", i, p.at, want)
+				}
+				got = append(got, p.active)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConcurrencySeriesBounded(t *testing.T) {
+	points := concurrencySeries([]interval{{at(0), at(100000)}}, time.Millisecond)
+	if len(points) > maxSeriesPoints {
+		t.Errorf("got %d points, want at most %d", len(points), maxSeriesPoints)
+	}
+	if last := points[len(points)-1]; last.at.Before(at(100000).Add(-points[1].at.Sub(points[0].at))) {
+		t.Errorf("series ends at %s, short of the last end", last.at)
+	}
+}
+
+func TestPeakConcurrency(t *testing.T) {
+	now := at(100)
+	for _, tc := range []struct {
+		name string
+		recs []invocationRecord
+		peak int
+		at   time.Time
+	}{
+		{name: "empty"},
+		{
+			name: "overlapping",
+			recs: []invocationRecord{
+				{started: at(0), ended: at(3)},
+				{started: at(1), ended: at(4)},
+				{started: at(2), ended: at(5)},
+				{started: at(4.5), ended: at(6)},
+			},
+			peak: 3, at: at(2),
+		},
+		{
+			name: "back to back",
+			recs: []invocationRecord{
+				{started: at(0), ended: at(1)},
+				{started: at(1), ended: at(2)},
+				{started: at(2), ended: at(3)},
+			},
+			peak: 1, at: at(0),
+		},
+		{
+			name: "nested",
+			recs: []invocationRecord{
+				{started: at(0), ended: at(10)},
+				{started: at(1), ended: at(9)},
+				{started: at(2), ended: at(3)},
+				{started: at(4), ended: at(5)},
+			},
+			peak: 3, at: at(2),
+		},
+		{
+			// Killed invocations never log an end, so they count until their lease expires.
+			name: "killed",
+			recs: []invocationRecord{
+				{started: at(0), expires: at(60)},
+				{started: at(30), ended: at(31)},
+				{started: at(61), ended: at(62)},
+			},
+			peak: 2, at: at(30),
+		},
+		{
+			// Invocations still running, whose leases haven't expired, count until now.
+			name: "still running",
+			recs: []invocationRecord{
+				{started: at(0), expires: at(1000)},
+				{started: at(50), expires: at(1000)},
+				{started: at(99), ended: at(100)},
+			},
+			peak: 3, at: at(99),
+		},
+		{
+			name: "zero length",
+			recs: []invocationRecord{{started: at(1), ended: at(1)}},
+			peak: 0,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			peak, peakAt := peakConcurrency(intervals(tc.recs, now))
+			if peak != tc.peak || !peakAt.Equal(tc.at) {
+				t.Errorf("got %d at %s, want %d at %s", peak, peakAt, tc.peak, tc.at)
+			}
+		})
+	}
+}
@@ -2,163 +2,269 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"math/big"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
-
-	"github.com/lib/pq"
-	"github.com/xo/dburl"
 )
 
-const codeTableNotFound pq.ErrorCode = "42P01"
+// leaseGrace is added to the requested wait when computing a lease's expiry if the platform
+// hasn't told us our deadline. It should comfortably exceed any DB latency we expect.
+const leaseGrace = time.Minute
 
-func Main(args map[string]interface{}) (out map[string]interface{}) {
-	ctx := context.Background()
-	databaseURL := os.Getenv("DATABASE_URL")
-	if databaseURL == "" {
-		return wrapErr(errors.New("DATABASE_URL is not set"))
-	}
-
-	dbURL, err := dburl.Parse(databaseURL)
-	if err != nil {
-		return wrapErr(err, "parsing DATABASE_URL")
-	}
+// maxTestNameLen is the width of the test_name columns.
+const maxTestNameLen = 40
 
-	// Open a DB connection.
-	dbPassword, _ := dbURL.User.Password()
-	dbName := strings.Trim(dbURL.Path, "/")
-	connectionString := fmt.Sprintf(
-		"host=%s port=%s user=%s dbname=%s password=%s sslmode=%s",
-		dbURL.Hostname(),
-		dbURL.Port(),
-		dbURL.User.Username(),
-		dbName, dbPassword,
-		dbURL.Query().Get("sslmode"))
+// maxShards bounds the `shards` setting.
+const maxShards = 1024
 
-	db, err := sql.Open("postgres", connectionString)
-	if err != nil {
-		return wrapErr(err, "connecting to postgres")
-	}
+// decMargin is reserved before the platform's deadline for dec() and the response, so an
+// invocation whose wait runs into the action's time limit still releases its lease.
+const decMargin = 500 * time.Millisecond
 
-	defer db.Close()
+func Main(args map[string]interface{}) (out map[string]interface{}) {
+	ctx, decCtx, cancel := invocationContexts()
+	defer cancel()
 
 	testName, _ := args["testname"].(string)
 	if testName == "" {
 		testName = "default"
 	}
+	if len(testName) > maxTestNameLen {
+		return wrapErr(args, http.StatusBadRequest,
+			fmt.Errorf("testname must be at most %d bytes, got %d", maxTestNameLen, len(testName)))
+	}
 
+	var err error
 	var wait time.Duration
 	if waitString, _ := args["wait"].(string); waitString != "" {
 		wait, err = time.ParseDuration(waitString)
 		if err != nil {
-			return wrapErr(err, "parsing duration")
+			return wrapErr(args, http.StatusBadRequest, err, "parsing duration")
+		}
+		if wait < 0 {
+			return wrapErr(args, http.StatusBadRequest, fmt.Errorf("wait must not be negative, got %s", wait))
 		}
 	}
 
-	var pgErr *pq.Error
+	mode, _ := args["mode"].(string)
+	switch mode {
+	case "", "migrate", "series", "report", "list", "compare":
+	default:
+		return wrapErr(args, http.StatusBadRequest, fmt.Errorf("unknown mode %q", mode))
+	}
+
+	f, err := parseFault(args)
+	if err != nil {
+		return wrapErr(args, http.StatusBadRequest, err, "parsing fault")
+	}
+
+	poolCfg, err := getPoolConfig(args)
+	if err != nil {
+		return wrapErr(args, http.StatusBadRequest, err, "parsing pool config")
+	}
+
+	// Spreading a test's counter over shards avoids every invocation queueing on one row lock at
+	// high concurrency. Stores without row locks ignore it.
+	shards, err := intSetting(args, "shards", "COUNTER_SHARDS", 0)
+	if err != nil {
+		return wrapErr(args, http.StatusBadRequest, err)
+	}
+	if shards < 0 || shards > maxShards {
+		return wrapErr(args, http.StatusBadRequest, fmt.Errorf("shards must be between 0 and %d, got %d", maxShards, shards))
+	}
+
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		return wrapErr(args, http.StatusInternalServerError, errors.New("DATABASE_URL is not set"))
+	}
+
+	// The store is shared by warm invocations of this instance, so we never close it here.
+	st, reused, err := getStore(databaseURL, poolCfg)
+	if err != nil {
+		return wrapErr(args, http.StatusInternalServerError, err, "connecting to database")
+	}
+
+	if mode == "migrate" {
+		return runMigrations(ctx, st, args)
+	}
+	if err = st.ensureSchema(ctx); err != nil {
+		return wrapErr(args, http.StatusInternalServerError, err, "migrating database")
+	}
+
+	switch mode {
+	case "series":
+		return series(ctx, st, testName, args)
+	case "report":
+		return respondReport(ctx, st, testName, args)
+	case "list":
+		return listRuns(ctx, st, args)
+	case "compare":
+		return compareRuns(ctx, st, testName, args)
+	}
 
 	if _, r := args["reset"]; r {
-		if err = reset(ctx, db, testName); err != nil {
-			if errors.As(err, &pgErr) && pgErr.Code != codeTableNotFound {
-				return wrapErr(err, "")
-			}
+		if err = st.archive(ctx, testName); err != nil {
+			return wrapErr(args, http.StatusInternalServerError, err, "resetting")
 		}
 	}
+	inv := &invocation{
+		id:       newLeaseID(),
+		testName: testName,
+		wait:     wait,
+		expires:  leaseExpiry(wait),
+		instance: instanceID,
+		shard:    pickShard(shards),
+	}
+
 	var active, peak, total int
-	if active, peak, total, err = inc(ctx, db, testName); err != nil {
-		if errors.As(err, &pgErr) && pgErr.Code == codeTableNotFound {
-			err = initDB(ctx, db)
-			if err != nil {
-				if errors.As(err, &pgErr) {
-					wrapErr(err, "initing database: error code"+string(pgErr.Code))
-				}
-				return wrapErr(err, "initing database")
-			}
-			active, peak, total, err = inc(ctx, db, testName)
-			if err != nil {
-				return wrapErr(err, "incrementing after create")
-			}
-		} else {
-			return wrapErr(err, "incrementing")
-		}
+	if active, peak, total, err = countInvocation(ctx, st, inv); err != nil {
+		return wrapErr(args, http.StatusInternalServerError, err, "incrementing")
 	}
 
+	// injected is set if a fault was injected after inc, so dec can record it. Exits and hangs
+	// never reach dec, leaving the lease to expire like a crashed invocation's.
+	var injected bool
 	defer func() {
-		if decErr := dec(ctx, db, testName); decErr != nil && err == nil {
+		outcome := outcomeOK
+		if injected {
+			outcome = outcomeFault
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			outcome = outcomeTimeout
+		} else if err != nil {
+			outcome = outcomeError
+		}
+		if decErr := st.dec(decCtx, inv, outcome); decErr != nil && err == nil {
 			// Always try to decrement, but we'll only display this error if there isn't already
 			// an err. This requires some diligence about not shaddowing `err` by declaring it in
 			// child scopes.
-			out = wrapErr(decErr, "decrementing")
+			out = wrapErr(args, http.StatusInternalServerError, decErr, "decrementing")
 		}
 	}()
 
+	if f != nil && f.due() {
+		injected = true
+		return f.inject(args)
+	}
+
 	if wait != 0 {
-		time.Sleep(wait)
+		if err = sleep(ctx, wait); err != nil {
+			return wrapErr(args, http.StatusGatewayTimeout, err, "waiting",
+				fmt.Sprintf("wait=%s doesn't fit before the action's deadline, less %s for cleanup", wait, decMargin))
+		}
+	}
+
+	var stats instanceStats
+	if stats, err = st.instanceStats(ctx, testName, inv.runID); err != nil {
+		return wrapErr(args, http.StatusInternalServerError, err, "querying instances")
 	}
 
-	return wrapHTML(fmt.Sprintf("active=%d<br>peak=%d<br>total=%d<br>wait=%s", active, peak, total, wait.String()))
+	return respond(args, http.StatusOK, fmt.Sprintf(
+		"run=%d\nactive=%d\npeak=%d\ntotal=%d\nwait=%s\n"+
+			"instance=%s\ninstance_seq=%d\ncold_start=%t\ndb_reused=%t\n"+
+			"instances=%d\ncold_starts=%d\nreuse_ratio=%.3f",
+		inv.runID, active, peak, total, wait.String(),
+		inv.instance, inv.seq, inv.seq == 1, reused,
+		stats.instances, stats.coldStarts, stats.reuseRatio()),
+		map[string]interface{}{
+			"run":          inv.runID,
+			"active":       active,
+			"peak":         peak,
+			"total":        total,
+			"wait":         wait.String(),
+			"wait_ms":      wait.Milliseconds(),
+			"instance":     inv.instance,
+			"instance_seq": inv.seq,
+			"cold_start":   inv.seq == 1,
+			"db_reused":    reused,
+			"instances":    stats.instances,
+			"cold_starts":  stats.coldStarts,
+			"reuse_ratio":  stats.reuseRatio(),
+		})
 }
 
-func wrapErr(err error, wrap ...string) map[string]interface{} {
-	if len(wrap) == 0 {
-		return wrapHTML(`<span style="color: red;">` + err.Error() + "</span>")
+// wrapErr responds with err, prefixed by wrap[0] and followed by any further lines of wrap. If err
+// came from a database driver, e.g. Postgres' SQLSTATE, its code is included as the error code.
+func wrapErr(args map[string]interface{}, status int, err error, wrap ...string) map[string]interface{} {
+	msg := err.Error()
+	if len(wrap) > 0 {
+		msg = wrap[0] + ": " + msg
+		if len(wrap) > 1 {
+			msg += "\n" + strings.Join(wrap[1:], "\n")
+		}
 	}
-	return wrapHTML(`<span style="color: red;">` + wrap[0] + ": " + err.Error() + "\n" + strings.Join(wrap[1:], "\n") + "</span>")
+	return respondErr(args, status, msg, errorCode(err))
 }
 
-func wrapHTML(body string) map[string]interface{} {
-	return map[string]interface{}{
-		"body": "<html><body><pre>" + string(body) + "</pre></body></html>",
+// newLeaseID returns an identifier for this invocation's lease. The activation ID is preferred
+// so leases can be matched up with platform logs.
+func newLeaseID() string {
+	if id := os.Getenv("__OW_ACTIVATION_ID"); id != "" {
+		return id
 	}
+	return randomID(16)
 }
 
-func initDB(ctx context.Context, db *sql.DB) error {
-	_, err := db.ExecContext(ctx, `
-	CREATE TABLE concurrency (
-		test_name    varchar(40) NOT NULL,
-		con_active   integer NOT NULL,
-		con_peak     integer NOT NULL,
-		con_total    integer NOT NULL,
-		PRIMARY KEY (test_name)
-	);
-	`)
-	return err
+// randomID returns n random bytes, hex encoded.
+func randomID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
 }
 
-func inc(ctx context.Context, db *sql.DB, testName string) (active, peak, total int, err error) {
-	err = db.QueryRowContext(ctx, `
-	INSERT INTO concurrency 
-		VALUES ($1, 1, 1, 1)
-		ON CONFLICT (test_name)
-		DO UPDATE SET 
-			con_active = concurrency.con_active + 1,
-			con_total = concurrency.con_total + 1,
-			con_peak = GREATEST(concurrency.con_peak, concurrency.con_active + 1)
-		RETURNING con_active, con_peak, con_total
-	`, testName).Scan(&active, &peak, &total)
+// pickShard returns a random shard in [1, shards], or 0 if sharding is off.
+func pickShard(shards int) int {
+	if shards == 0 {
+		return 0
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(shards)))
 	if err != nil {
-		return 0, 0, 0, fmt.Errorf("inserting: %w", err)
+		return int(time.Now().UnixNano()%int64(shards)) + 1
 	}
-	return
+	return int(n.Int64()) + 1
 }
 
-func dec(ctx context.Context, db *sql.DB, testName string) error {
-	_, err := db.ExecContext(ctx, `
-	UPDATE concurrency SET con_active = con_active - 1 WHERE test_name = $1
-	`, testName)
-	if err != nil {
-		return fmt.Errorf("decrementing: %w", err)
+// invocationContexts returns a context for the invocation's work, which ends decMargin before the
+// platform's deadline, and one for dec(), which may use the rest. Without a deadline neither ends.
+func invocationContexts() (work, dec context.Context, cancel context.CancelFunc) {
+	deadline, ok := platformDeadline()
+	if !ok {
+		return context.Background(), context.Background(), func() {}
+	}
+	work, cancelWork := context.WithDeadline(context.Background(), deadline.Add(-decMargin))
+	dec, cancelDec := context.WithDeadline(context.Background(), deadline)
+	return work, dec, func() {
+		cancelWork()
+		cancelDec()
 	}
-	return nil
 }
 
-func reset(ctx context.Context, db *sql.DB, testName string) error {
-	_, err := db.ExecContext(ctx, `DELETE FROM concurrency WHERE test_name = $1`, testName)
-	if err != nil {
-		return fmt.Errorf("resetting: %w", err)
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// leaseExpiry returns the time after which this invocation's lease may be reaped. If the platform
+// provides a deadline we can't possibly be running past it, otherwise we allow the requested wait
+// plus leaseGrace.
+func leaseExpiry(wait time.Duration) time.Time {
+	if deadline, ok := platformDeadline(); ok {
+		return deadline
 	}
-	return nil
+	return time.Now().Add(wait + leaseGrace)
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRejectedCallsTakeNoSeq checks calls which never reach inc, or whose inc fails, leave the
+// instance's sequence alone, so its first counted invocation is still seen as its cold start.
+func TestRejectedCallsTakeNoSeq(t *testing.T) {
+	before := currentSeq()
+	for _, args := range []map[string]interface{}{
+		{"mode": "bogus"},
+		{"testname": strings.Repeat("x", maxTestNameLen+1)},
+		{"wait": "-1s"},
+		{"shards": "-1"},
+	} {
+		args["format"] = "json"
+		if out := Main(args); out["statusCode"] != http.StatusBadRequest {
+			t.Errorf("%v: status %v, want 400", args, out["statusCode"])
+		}
+	}
+	if after := currentSeq(); after != before {
+		t.Errorf("instance seq went from %d after rejected calls to %d", before, after)
+	}
+
+	ctx := context.Background()
+	inv := testInvocation("seq", 0)
+	if _, _, _, err := countInvocation(ctx, failingStore{}, inv); err == nil {
+		t.Fatal("want inc's error")
+	}
+	if after := currentSeq(); after != before || inv.seq != 0 {
+		t.Errorf("a failed inc took seq %d, and instance seq went from %d to %d", inv.seq, before, after)
+	}
+
+	st := sqliteTestStore(t)
+	if err := st.ensureSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := countInvocation(ctx, st, inv); err != nil {
+		t.Fatal(err)
+	}
+	if inv.seq != before+1 || currentSeq() != before+1 {
+		t.Errorf("the next counted invocation took seq %d, want %d", inv.seq, before+1)
+	}
+}
+
+// failingStore is a store whose inc always fails.
+type failingStore struct {
+	store
+}
+
+func (failingStore) inc(ctx context.Context, inv *invocation) (active, peak, total int, err error) {
+	return 0, 0, 0, errors.New("inc failed")
+}
+
+func currentSeq() int64 {
+	seqMu.Lock()
+	defer seqMu.Unlock()
+	return instanceSeq
+}
+
+// TestDeadlineReservesDecMargin runs a wait past a short platform deadline, and checks the wait
+// is cut short decMargin before the deadline and that dec still records the invocation's end.
+func TestDeadlineReservesDecMargin(t *testing.T) {
+	sqliteTestDatabase(t)
+	deadline := time.Now().Add(decMargin + time.Second).Truncate(time.Millisecond)
+	t.Setenv("__OW_DEADLINE", strconv.FormatInt(deadline.UnixMilli(), 10))
+
+	work, dec, cancel := invocationContexts()
+	defer cancel()
+	if d, ok := work.Deadline(); !ok || !d.Equal(deadline.Add(-decMargin)) {
+		t.Errorf("work deadline = %s, %t; want %s", d, ok, deadline.Add(-decMargin))
+	}
+	if d, ok := dec.Deadline(); !ok || !d.Equal(deadline) {
+		t.Errorf("dec deadline = %s, %t; want %s", d, ok, deadline)
+	}
+
+	args := map[string]interface{}{"testname": "deadline", "wait": "10s", "format": "json"}
+	out := Main(args)
+	ended := time.Now()
+	if out["statusCode"] != http.StatusGatewayTimeout {
+		t.Fatalf("status %v, want 504: %v", out["statusCode"], out["body"])
+	}
+	if ended.Before(deadline.Add(-decMargin)) || !ended.Before(deadline) {
+		t.Errorf("Main returned at %s, want between %s and the deadline %s", ended, deadline.Add(-decMargin), deadline)
+	}
+
+	// The report is a later invocation, without the expired deadline.
+	t.Setenv("__OW_DEADLINE", "")
+	out = Main(map[string]interface{}{"testname": "deadline", "mode": "report", "format": "json"})
+	body, _ := out["body"].(map[string]interface{})
+	if out["statusCode"] != http.StatusOK || body["active"] != 0 || body["completed"] != 1 || body["errors"] != 1 {
+		t.Errorf("report = %v, want the timed out invocation ended and its lease released", body)
+	}
+}
+
+// sqliteTestDatabase points Main at a new SQLite database in t's temp dir, closing the instance's
+// shared store when the test ends.
+func sqliteTestDatabase(t *testing.T) {
+	t.Helper()
+	if !sqliteAvailable {
+		t.Skip("SQLite needs cgo")
+	}
+	t.Setenv("DATABASE_URL", "sqlite:"+filepath.Join(t.TempDir(), "main.db"))
+	t.Cleanup(func() {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		if sharedStore != nil {
+			sharedStore.close()
+			sharedStore, sharedStoreURL = nil, ""
+		}
+	})
+}
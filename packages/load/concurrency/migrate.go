@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ensureSchema migrates the database the first time this instance uses it. Warm invocations skip
+// straight past it.
+func (s *sqlStore) ensureSchema(ctx context.Context) error {
+	s.schemaMu.Lock()
+	defer s.schemaMu.Unlock()
+	if s.schemaReady {
+		return nil
+	}
+	if _, _, err := s.migrate(ctx); err != nil {
+		return err
+	}
+	s.schemaReady = true
+	return nil
+}
+
+// repairSchema migrates the database again after inc found part of the schema missing, e.g.
+// because tables were dropped between test runs. schema_version may well survive that, so every
+// migration is re-applied regardless of it; they're all idempotent.
+func (s *sqlStore) repairSchema(ctx context.Context) error {
+	s.schemaMu.Lock()
+	defer s.schemaMu.Unlock()
+	s.schemaReady = false
+	if _, _, err := s.applyMigrations(ctx, true); err != nil {
+		return err
+	}
+	s.schemaReady = true
+	return nil
+}
+
+// migrate applies any pending migrations, returning the schema version before and after. The
+// dialect's migration lock keeps a herd of cold starts against a fresh database from racing to
+// create the same tables.
+func (s *sqlStore) migrate(ctx context.Context) (from, to int, err error) {
+	return s.applyMigrations(ctx, false)
+}
+
+// applyMigrations implements migrate. If all is set, migrations which schema_version records as
+// applied are applied again.
+func (s *sqlStore) applyMigrations(ctx context.Context, all bool) (from, to int, err error) {
+	migrations := s.d.migrations
+	// Checking without the lock keeps the common, already migrated, case cheap.
+	if from, err = s.schemaVersion(ctx, s.db); !all && err == nil && from >= len(migrations) {
+		return from, from, nil
+	}
+
+	// The lock is held by a connection of our own, so it can be released once the migrations are
+	// committed and before the connection goes back to the pool.
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Close()
+	unlock, err := s.d.lockMigrations(ctx, conn)
+	if err != nil {
+		return 0, 0, fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	from, err = s.migrateLocked(ctx, conn, all)
+	if unlockErr := unlock(); unlockErr != nil {
+		// Discarding the connection ends its session, and with it the lock.
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return from, len(migrations), nil
+}
+
+// migrateLocked applies the migrations in a transaction on conn, which holds the migration lock,
+// returning the schema version before.
+func (s *sqlStore) migrateLocked(ctx context.Context, conn *sql.Conn, all bool) (from int, err error) {
+	migrations := s.d.migrations
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, s.d.schemaVersionTable); err != nil {
+		return 0, fmt.Errorf("creating schema_version: %w", err)
+	}
+	// Someone else may have migrated while we waited for the lock.
+	if from, err = s.schemaVersion(ctx, tx); err != nil {
+		return 0, err
+	}
+	start := from
+	if all {
+		start = 0
+	}
+	for v := start; v < len(migrations); v++ {
+		if _, err = tx.ExecContext(ctx, migrations[v]); err != nil {
+			return 0, fmt.Errorf("applying migration %d: %w", v+1, err)
+		}
+		if v < from {
+			continue
+		}
+		_, err = s.exec(ctx, tx, `
+		INSERT INTO schema_version (version, applied_at) VALUES ($1, $2)
+		`, v+1, time.Now().UTC())
+		if err != nil {
+			return 0, fmt.Errorf("recording migration %d: %w", v+1, err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing migrations: %w", err)
+	}
+	return from, nil
+}
+
+// schemaVersion returns the latest applied migration, or an error if schema_version doesn't exist.
+func (s *sqlStore) schemaVersion(ctx context.Context, q querier) (int, error) {
+	var v int
+	if err := q.QueryRowContext(ctx, `SELECT COALESCE(max(version), 0) FROM schema_version`).Scan(&v); err != nil {
+		return 0, fmt.Errorf("querying schema version: %w", err)
+	}
+	return v, nil
+}
+
+// runMigrations responds to mode=migrate, applying any pending migrations.
+func runMigrations(ctx context.Context, st store, args map[string]interface{}) map[string]interface{} {
+	from, to, err := st.migrate(ctx)
+	if err != nil {
+		return wrapErr(args, http.StatusInternalServerError, err, "migrating database")
+	}
+	return respond(args, http.StatusOK,
+		fmt.Sprintf("from_version=%d\nversion=%d", from, to),
+		map[string]interface{}{
+			"from_version": from,
+			"version":      to,
+		})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRepairDroppedTable drops a table from under a migrated instance, whose schema_version still
+// records every migration, and checks inc recovers.
+func TestRepairDroppedTable(t *testing.T) {
+	ctx := context.Background()
+	for name, open := range sqlTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			st := open(t)
+			if err := st.ensureSchema(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, _, err := st.inc(ctx, testInvocation("repair", 0)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := st.db.ExecContext(ctx, `DROP TABLE concurrency`); err != nil {
+				t.Fatal(err)
+			}
+			_, _, total, err := st.inc(ctx, testInvocation("repair", 0))
+			if err != nil {
+				t.Fatalf("inc after dropping concurrency: %v", err)
+			}
+			if total != 1 {
+				t.Errorf("total = %d, want 1 as the counter was dropped", total)
+			}
+			if v, err := st.schemaVersion(ctx, st.db); err != nil || v != len(st.d.migrations) {
+				t.Errorf("schema version = %d, %v; want %d", v, err, len(st.d.migrations))
+			}
+		})
+	}
+}
+
+// TestMigrateHerd starts a herd of instances, each with its own pool, against a fresh Postgres
+// schema at once, as a burst of cold starts would.
+func TestMigrateHerd(t *testing.T) {
+	const instances = 32
+	ctx := context.Background()
+	url := postgresTestURL(t)
+
+	stores := make([]store, instances)
+	for i := range stores {
+		st, err := openStore(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer st.close()
+		stores[i] = st
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, instances)
+	start := make(chan struct{})
+	for _, st := range stores {
+		wg.Add(1)
+		go func(st store) {
+			defer wg.Done()
+			<-start
+			if err := st.ensureSchema(ctx); err != nil {
+				errs <- err
+				return
+			}
+			if _, _, _, err := st.inc(ctx, testInvocation("herd", 0)); err != nil {
+				errs <- err
+			}
+		}(st)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	st := stores[0].(*sqlStore)
+	var versions, total int
+	if err := st.db.QueryRowContext(ctx, `SELECT count(*) FROM schema_version`).Scan(&versions); err != nil {
+		t.Fatal(err)
+	}
+	if versions != len(st.d.migrations) {
+		t.Errorf("schema_version has %d rows, want %d", versions, len(st.d.migrations))
+	}
+	if err := st.db.QueryRowContext(ctx, `SELECT con_total FROM concurrency WHERE test_name = 'herd'`).Scan(&total); err != nil {
+		t.Fatal(err)
+	}
+	if total != instances {
+		t.Errorf("total = %d, want %d", total, instances)
+	}
+}
+
+// TestMigrateReleasesLock migrates from one instance and then another. If the first kept the
+// migration lock, e.g. on a pooled MySQL session, the second waits for it until it times out.
+func TestMigrateReleasesLock(t *testing.T) {
+	url := serverTestURL(t)
+	for i := 0; i < 2; i++ {
+		st, err := openStore(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer st.close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, _, err = st.(*sqlStore).applyMigrations(ctx, true)
+		cancel()
+		if err != nil {
+			t.Fatalf("migrating from instance %d: %v", i+1, err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/xo/dburl"
+)
+
+// MySQL error numbers which inc handles.
+const (
+	mysqlNoSuchTable     = 1146
+	mysqlBadField        = 1054
+	mysqlLockWaitTimeout = 1205
+	mysqlDeadlock        = 1213
+)
+
+// mysqlMigrationLock names the lock held while migrating.
+const mysqlMigrationLock = "concurrency_migrate"
+
+// mysqlMigrationLockTimeout bounds how long an instance waits for another to finish migrating,
+// in seconds.
+const mysqlMigrationLockTimeout = 60
+
+var mysqlDialect = &dialect{
+	name:          "mysql",
+	questionMarks: true,
+	migrations:    mysqlMigrations,
+	schemaVersionTable: `
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version      integer NOT NULL,
+		applied_at   datetime(6) NOT NULL,
+		PRIMARY KEY (version)
+	)
+	`,
+	upsertCounter: `
+	INSERT INTO concurrency (test_name, con_active, con_peak, con_total, run_id, run_started_at)
+		VALUES ($1, 0, 0, 1, 1, $2)
+		ON DUPLICATE KEY UPDATE
+			con_total = con_total + 1
+	`,
+	insertCounter: `
+	INSERT IGNORE INTO concurrency (test_name, con_active, con_peak, con_total, run_id, run_started_at)
+		VALUES ($1, 0, 0, 0, 1, $2)
+	`,
+	upsertShard: `
+	INSERT INTO concurrency_shard (test_name, shard, con_peak, con_total)
+		VALUES ($1, $2, 0, 1)
+		ON DUPLICATE KEY UPDATE
+			con_total = con_total + 1
+	`,
+	shareCounter: `SELECT run_id FROM concurrency WHERE test_name = $1 LOCK IN SHARE MODE`,
+	lockMigrations: func(ctx context.Context, conn *sql.Conn) (func() error, error) {
+		// GET_LOCK is held by the session rather than a transaction, so it must be released
+		// before the connection goes back to the pool.
+		var got sql.NullInt64
+		err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, mysqlMigrationLock, mysqlMigrationLockTimeout).Scan(&got)
+		if err != nil {
+			return nil, err
+		}
+		if got.Int64 != 1 {
+			return nil, fmt.Errorf("timed out waiting for %s", mysqlMigrationLock)
+		}
+		return func() error {
+			_, err := conn.ExecContext(context.Background(), `DO RELEASE_LOCK(?)`, mysqlMigrationLock)
+			return err
+		}, nil
+	},
+	errorCode: func(err error) string {
+		var myErr *mysql.MySQLError
+		if errors.As(err, &myErr) {
+			return strconv.Itoa(int(myErr.Number))
+		}
+		return ""
+	},
+	retryable: func(err error) (retry, remigrate bool) {
+		var myErr *mysql.MySQLError
+		if !errors.As(err, &myErr) {
+			return false, false
+		}
+		switch myErr.Number {
+		case mysqlNoSuchTable, mysqlBadField:
+			return false, true
+		case mysqlLockWaitTimeout, mysqlDeadlock:
+			return true, false
+		}
+		return false, false
+	},
+}
+
+// mysqlMigrations are the MySQL schema's history. MySQL DDL isn't transactional, so each must be
+// safe to re-run after a partial failure.
+var mysqlMigrations = []string{
+	`
+	CREATE TABLE IF NOT EXISTS concurrency (
+		test_name       varchar(40) NOT NULL,
+		con_active      integer NOT NULL,
+		con_peak        integer NOT NULL,
+		con_total       integer NOT NULL,
+		run_id          integer NOT NULL DEFAULT 1,
+		run_started_at  datetime(6) NOT NULL,
+		PRIMARY KEY (test_name)
+	);
+	CREATE TABLE IF NOT EXISTS concurrency_lease (
+		lease_id     varchar(64) NOT NULL,
+		test_name    varchar(40) NOT NULL,
+		expires_at   datetime(6) NOT NULL,
+		PRIMARY KEY (lease_id),
+		KEY concurrency_lease_test_name_expires_at (test_name, expires_at)
+	);
+	CREATE TABLE IF NOT EXISTS concurrency_invocation (
+		id             bigint NOT NULL AUTO_INCREMENT,
+		activation_id  varchar(64) NOT NULL,
+		test_name      varchar(40) NOT NULL,
+		run_id         integer NOT NULL DEFAULT 1,
+		wait_ms        bigint NOT NULL,
+		started_at     datetime(6) NOT NULL,
+		expires_at     datetime(6) NOT NULL,
+		instance_id    varchar(32) NOT NULL DEFAULT '',
+		instance_seq   bigint NOT NULL DEFAULT 0,
+		PRIMARY KEY (id),
+		KEY concurrency_invocation_test_name_run_id_started_at (test_name, run_id, started_at)
+	);
+	CREATE TABLE IF NOT EXISTS concurrency_invocation_end (
+		activation_id  varchar(64) NOT NULL,
+		test_name      varchar(40) NOT NULL,
+		ended_at       datetime(6) NOT NULL,
+		outcome        varchar(16) NOT NULL,
+		PRIMARY KEY (activation_id)
+	);
+	CREATE TABLE IF NOT EXISTS concurrency_run (
+		test_name    varchar(40) NOT NULL,
+		run_id       integer NOT NULL,
+		started_at   datetime(6) NOT NULL,
+		archived_at  datetime(6) NOT NULL,
+		con_peak     integer NOT NULL,
+		con_total    integer NOT NULL,
+		PRIMARY KEY (test_name, run_id)
+	);
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS concurrency_shard (
+		test_name    varchar(40) NOT NULL,
+		shard        integer NOT NULL,
+		con_peak     integer NOT NULL,
+		con_total    integer NOT NULL,
+		PRIMARY KEY (test_name, shard)
+	);
+	`,
+}
+
+// openMySQL opens a MySQL store. Times are stored as UTC DATETIMEs, and migrations need
+// multi-statement execs.
+func openMySQL(u *dburl.URL) (store, error) {
+	cfg, err := mysql.ParseDSN(u.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("parsing DATABASE_URL: %w", err)
+	}
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	cfg.MultiStatements = true
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("parsing DATABASE_URL: %w", err)
+	}
+	return &sqlStore{db: sql.OpenDB(connector), d: mysqlDialect}, nil
+}
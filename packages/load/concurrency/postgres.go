@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/xo/dburl"
+)
+
+// Postgres error codes which inc handles.
+const (
+	codeTableNotFound        pq.ErrorCode = "42P01"
+	codeColumnNotFound       pq.ErrorCode = "42703"
+	codeSerializationFailure pq.ErrorCode = "40001"
+	codeDeadlockDetected     pq.ErrorCode = "40P01"
+	codeLockNotAvailable     pq.ErrorCode = "55P03"
+)
+
+// postgresMigrationLockKey is the advisory lock held while migrating.
+const postgresMigrationLockKey = 0x636f6e63 // "conc"
+
+var postgresDialect = &dialect{
+	name:       "postgres",
+	migrations: postgresMigrations,
+	schemaVersionTable: `
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version      integer NOT NULL,
+		applied_at   timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (version)
+	);
+	`,
+	upsertCounter: `
+	INSERT INTO concurrency (test_name, con_active, con_peak, con_total, run_id, run_started_at)
+		VALUES ($1, 0, 0, 1, 1, $2)
+		ON CONFLICT (test_name)
+		DO UPDATE SET 
+			con_total = concurrency.con_total + 1
+	`,
+	insertCounter: `
+	INSERT INTO concurrency (test_name, con_active, con_peak, con_total, run_id, run_started_at)
+		VALUES ($1, 0, 0, 0, 1, $2)
+		ON CONFLICT (test_name) DO NOTHING
+	`,
+	upsertShard: `
+	INSERT INTO concurrency_shard (test_name, shard, con_peak, con_total)
+		VALUES ($1, $2, 0, 1)
+		ON CONFLICT (test_name, shard)
+		DO UPDATE SET
+			con_total = concurrency_shard.con_total + 1
+	`,
+	shareCounter: `SELECT run_id FROM concurrency WHERE test_name = $1 FOR SHARE`,
+	lockMigrations: func(ctx context.Context, conn *sql.Conn) (func() error, error) {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, postgresMigrationLockKey); err != nil {
+			return nil, err
+		}
+		return func() error {
+			// ctx may be done by now, but the lock must be released regardless.
+			_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, postgresMigrationLockKey)
+			return err
+		}, nil
+	},
+	errorCode: func(err error) string {
+		var pgErr *pq.Error
+		if errors.As(err, &pgErr) {
+			return string(pgErr.Code)
+		}
+		return ""
+	},
+	retryable: func(err error) (retry, remigrate bool) {
+		var pgErr *pq.Error
+		if !errors.As(err, &pgErr) {
+			return false, false
+		}
+		switch pgErr.Code {
+		case codeTableNotFound, codeColumnNotFound:
+			return false, true
+		case codeSerializationFailure, codeDeadlockDetected, codeLockNotAvailable:
+			return true, false
+		}
+		return false, false
+	},
+}
+
+// postgresMigrations are the Postgres schema's history. Each must be idempotent, as databases
+// created before schema_version existed already have some of these tables.
+var postgresMigrations = []string{
+	`
+	CREATE TABLE IF NOT EXISTS concurrency (
+		test_name    varchar(40) NOT NULL,
+		con_active   integer NOT NULL,
+		con_peak     integer NOT NULL,
+		con_total    integer NOT NULL,
+		PRIMARY KEY (test_name)
+	);
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS concurrency_lease (
+		lease_id     varchar(64) NOT NULL,
+		test_name    varchar(40) NOT NULL,
+		expires_at   timestamptz NOT NULL,
+		PRIMARY KEY (lease_id)
+	);
+	CREATE INDEX IF NOT EXISTS concurrency_lease_test_name_expires_at
+		ON concurrency_lease (test_name, expires_at);
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS concurrency_invocation (
+		id             bigserial NOT NULL,
+		activation_id  varchar(64) NOT NULL,
+		test_name      varchar(40) NOT NULL,
+		wait_ms        bigint NOT NULL,
+		started_at     timestamptz NOT NULL,
+		expires_at     timestamptz NOT NULL,
+		PRIMARY KEY (id)
+	);
+	CREATE INDEX IF NOT EXISTS concurrency_invocation_test_name_started_at
+		ON concurrency_invocation (test_name, started_at);
+	CREATE TABLE IF NOT EXISTS concurrency_invocation_end (
+		activation_id  varchar(64) NOT NULL,
+		test_name      varchar(40) NOT NULL,
+		ended_at       timestamptz NOT NULL,
+		outcome        varchar(16) NOT NULL,
+		PRIMARY KEY (activation_id)
+	);
+	`,
+	`
+	ALTER TABLE concurrency_invocation
+		ADD COLUMN IF NOT EXISTS instance_id varchar(32) NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS instance_seq bigint NOT NULL DEFAULT 0;
+	`,
+	`
+	ALTER TABLE concurrency
+		ADD COLUMN IF NOT EXISTS run_id integer NOT NULL DEFAULT 1,
+		ADD COLUMN IF NOT EXISTS run_started_at timestamptz NOT NULL DEFAULT now();
+	ALTER TABLE concurrency_invocation
+		ADD COLUMN IF NOT EXISTS run_id integer NOT NULL DEFAULT 1;
+	DROP INDEX IF EXISTS concurrency_invocation_test_name_started_at;
+	CREATE INDEX IF NOT EXISTS concurrency_invocation_test_name_run_id_started_at
+		ON concurrency_invocation (test_name, run_id, started_at);
+	CREATE TABLE IF NOT EXISTS concurrency_run (
+		test_name    varchar(40) NOT NULL,
+		run_id       integer NOT NULL,
+		started_at   timestamptz NOT NULL,
+		archived_at  timestamptz NOT NULL,
+		con_peak     integer NOT NULL,
+		con_total    integer NOT NULL,
+		PRIMARY KEY (test_name, run_id)
+	);
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS concurrency_shard (
+		test_name    varchar(40) NOT NULL,
+		shard        integer NOT NULL,
+		con_peak     integer NOT NULL,
+		con_total    integer NOT NULL,
+		PRIMARY KEY (test_name, shard)
+	);
+	`,
+}
+
+func openPostgres(u *dburl.URL) (store, error) {
+	dsn, err := postgresDSN(u)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("parsing DATABASE_URL: %w", err)
+	}
+	return &sqlStore{db: sql.OpenDB(connector), d: postgresDialect}, nil
+}
+
+// dsnQuoter escapes a value for a single-quoted libpq connection string.
+var dsnQuoter = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// postgresDSN builds a libpq key/value connection string from the URL. Every query parameter is
+// passed through as an option (sslmode, sslrootcert, connect_timeout, application_name, ...), and
+// all values are quoted so credentials containing spaces, quotes or backslashes survive intact.
+func postgresDSN(u *dburl.URL) (string, error) {
+	if u.Driver != "postgres" {
+		return "", fmt.Errorf("unsupported DATABASE_URL scheme %q", u.OriginalScheme)
+	}
+	q := u.Query()
+	host, port, dbName := u.Hostname(), u.Port(), strings.TrimPrefix(u.Path, "/")
+	if u.Transport == "unix" {
+		// postgres+unix:/path/to/socket/dir/dbname
+		host, dbName = path.Split(u.Path)
+		host = strings.TrimSuffix(host, "/")
+	}
+	for k, v := range map[string]string{"host": host, "port": port, "dbname": dbName} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	if u.User != nil {
+		q.Set("user", u.User.Username())
+		if password, ok := u.User.Password(); ok {
+			q.Set("password", password)
+		}
+	}
+
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	opts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		opts = append(opts, k+"='"+dsnQuoter.Replace(q.Get(k))+"'")
+	}
+	return strings.Join(opts, " "), nil
+}
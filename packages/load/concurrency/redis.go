@@ -0,0 +1,516 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisSchemaVersion is the version of the key layout below. Bump it, and teach migrate to convert
+// the old layout, if the layout changes incompatibly.
+const redisSchemaVersion = 1
+
+// redisKeyTTL is how long a test's keys outlive its last invocation. A run's own keys are only
+// kept alive by invocations in that run, so archived runs' logs expire too.
+const redisKeyTTL = 7 * 24 * time.Hour
+
+// Keys. Each test's keys share a {hash tag} so its scripts touch a single slot. Times are stored as
+// Unix microseconds.
+const (
+	// redisSchemaKey holds the key layout version.
+	redisSchemaKey = "concurrency:schema_version"
+	// redisTestsKey is a set of every test name.
+	redisTestsKey = "concurrency:tests"
+)
+
+// redisKeys are the keys of a test.
+type redisKeys struct {
+	// counter is a hash of the current run's run_id, started, peak and total.
+	counter string
+	// leases is a sorted set of lease IDs scored by expiry.
+	leases string
+	// runs is a hash of archived run IDs to "started archived peak total".
+	runs string
+	// runPrefix is followed by a run ID and ":" to make the run's keys.
+	runPrefix string
+}
+
+// redisRunKeys are the keys of one run of a test.
+type redisRunKeys struct {
+	// log is a hash of lease IDs to "started expires wait_ms instance seq".
+	log string
+	// ended is a hash of lease IDs to "ended outcome".
+	ended string
+	// instances is a hash of instance IDs to the number of invocations they served.
+	instances string
+	// stats is a hash of "invocations", "instances" and "cold_starts".
+	stats string
+}
+
+func keysFor(testName string) redisKeys {
+	p := "concurrency:{" + testName + "}"
+	return redisKeys{
+		counter:   p,
+		leases:    p + ":leases",
+		runs:      p + ":runs",
+		runPrefix: p + ":run:",
+	}
+}
+
+// run returns the keys of a run.
+func (k redisKeys) run(runID int) redisRunKeys {
+	p := k.runPrefix + strconv.Itoa(runID) + ":"
+	return redisRunKeys{
+		log:       p + "log",
+		ended:     p + "ended",
+		instances: p + "instances",
+		stats:     p + "stats",
+	}
+}
+
+// incScript starts the counter if needed, reaps expired leases, takes a lease, logs the invocation
+// and bumps the counters, all atomically, and sets every key it writes to expire redisKeyTTL from
+// now. The caller passes the keys of the run it expects to be current; if the test has moved on to
+// another run, nothing is written and only that run's ID is returned, so the caller can try again
+// with its keys.
+//
+// KEYS: counter, leases, runs, run's log, run's instances, run's stats
+// ARGV: now, lease ID, expires, log record, instance, cold start (0 or 1), expected run ID, TTL in
+// seconds
+// Returns: run ID, active, peak, total; or the current run ID alone
+var incScript = redis.NewScript(`
+local run = redis.call('HGET', KEYS[1], 'run_id')
+if not run then
+	run = '1'
+end
+if run ~= ARGV[7] then
+	return {tonumber(run)}
+end
+if redis.call('HSETNX', KEYS[1], 'run_id', 1) == 1 then
+	redis.call('HSET', KEYS[1], 'started', ARGV[1], 'peak', 0, 'total', 0)
+end
+
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', '(' .. ARGV[1])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[2])
+redis.call('HSET', KEYS[4], ARGV[2], ARGV[4])
+
+redis.call('HINCRBY', KEYS[6], 'invocations', 1)
+if redis.call('HINCRBY', KEYS[5], ARGV[5], 1) == 1 then
+	redis.call('HINCRBY', KEYS[6], 'instances', 1)
+end
+if ARGV[6] == '1' then
+	redis.call('HINCRBY', KEYS[6], 'cold_starts', 1)
+end
+
+local total = redis.call('HINCRBY', KEYS[1], 'total', 1)
+local active = redis.call('ZCARD', KEYS[2])
+local peak = tonumber(redis.call('HGET', KEYS[1], 'peak'))
+if active > peak then
+	peak = active
+	redis.call('HSET', KEYS[1], 'peak', peak)
+end
+
+for _, key in ipairs(KEYS) do
+	redis.call('EXPIRE', key, ARGV[8])
+end
+return {tonumber(run), active, peak, total}
+`)
+
+// incAttempts bounds how many runs inc tries, in case archives keep moving the test on under it.
+const incAttempts = 3
+
+// archiveScript saves the current run and starts a new one. The archived run's keys are left to
+// expire redisKeyTTL after its last invocation.
+//
+// KEYS: counter, leases, runs
+// ARGV: now, TTL in seconds
+var archiveScript = redis.NewScript(`
+local c = redis.call('HMGET', KEYS[1], 'run_id', 'started', 'peak', 'total')
+if not c[1] then
+	return 0
+end
+redis.call('HSET', KEYS[3], c[1], c[2] .. ' ' .. ARGV[1] .. ' ' .. c[3] .. ' ' .. c[4])
+redis.call('EXPIRE', KEYS[3], ARGV[2])
+redis.call('HSET', KEYS[1], 'run_id', tonumber(c[1]) + 1, 'started', ARGV[1], 'peak', 0, 'total', 0)
+redis.call('DEL', KEYS[2])
+return 1
+`)
+
+// redisStore is a store backed by Redis, or anything speaking its protocol with Lua scripting.
+// Every change to a test's counters is a single script, so invocations never wait on a row lock
+// and there's nothing for sharding to gain; invocation.shard is ignored.
+type redisStore struct {
+	opts *redis.Options
+
+	// clientMu guards client, which is created by the first setPool.
+	clientMu sync.Mutex
+	client   *redis.Client
+
+	schemaMu sync.Mutex
+	// schemaReady is set once this instance has checked the key layout version.
+	schemaReady bool
+
+	testsMu sync.Mutex
+	// registered holds the tests this instance has added to redisTestsKey.
+	registered map[string]bool
+	// lastRun holds the run ID each test's last inc on this instance found, which the next one
+	// expects.
+	lastRun map[string]int
+}
+
+func openRedis(databaseURL string) (store, error) {
+	opts, err := redis.ParseURL(databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing DATABASE_URL: %w", err)
+	}
+	return &redisStore{opts: opts, registered: map[string]bool{}, lastRun: map[string]int{}}, nil
+}
+
+// setPool sizes the client's pool. go-redis can't resize a live pool, so the limits in effect
+// when this instance's first invocation connects are kept; the idle limit has no equivalent.
+func (s *redisStore) setPool(cfg poolConfig) {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	if s.client != nil {
+		return
+	}
+	opts := *s.opts
+	opts.PoolSize = cfg.maxOpenConns
+	opts.MaxConnAge = cfg.connMaxLifetime
+	s.client = redis.NewClient(&opts)
+}
+
+func (s *redisStore) close() error {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	return s.client.Close()
+}
+
+// ensureSchema checks the key layout version the first time it's called on this instance.
+func (s *redisStore) ensureSchema(ctx context.Context) error {
+	s.schemaMu.Lock()
+	defer s.schemaMu.Unlock()
+	if s.schemaReady {
+		return nil
+	}
+	if _, _, err := s.migrate(ctx); err != nil {
+		return err
+	}
+	s.schemaReady = true
+	return nil
+}
+
+// migrate records the key layout version. There's nothing to convert yet, but a newer layout
+// written by another deployment is refused rather than corrupted.
+func (s *redisStore) migrate(ctx context.Context) (from, to int, err error) {
+	from, err = s.client.Get(ctx, redisSchemaKey).Int()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, 0, fmt.Errorf("querying schema version: %w", err)
+	}
+	if from > redisSchemaVersion {
+		return 0, 0, fmt.Errorf("schema version %d is newer than this function's %d", from, redisSchemaVersion)
+	}
+	if from < redisSchemaVersion {
+		if err = s.client.Set(ctx, redisSchemaKey, redisSchemaVersion, 0).Err(); err != nil {
+			return 0, 0, fmt.Errorf("recording schema version: %w", err)
+		}
+	}
+	return from, redisSchemaVersion, nil
+}
+
+// inc runs incScript, first against the run this instance last saw for the test and then, if the
+// test has been archived since, against the run the script found. go-redis retries network errors
+// itself, so unlike sqlStore there's nothing else worth retrying.
+func (s *redisStore) inc(ctx context.Context, inv *invocation) (active, peak, total int, err error) {
+	runID, err := s.register(ctx, inv.testName)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	now := time.Now()
+	k := keysFor(inv.testName)
+	record := fmt.Sprintf("%d %d %d %s %d",
+		now.UnixMicro(), inv.expires.UnixMicro(), inv.wait.Milliseconds(), inv.instance, inv.seq)
+	coldStart := 0
+	if inv.seq == 1 {
+		coldStart = 1
+	}
+	for attempt := 1; ; attempt++ {
+		rk := k.run(runID)
+		res, err := incScript.Run(ctx, s.client,
+			[]string{k.counter, k.leases, k.runs, rk.log, rk.instances, rk.stats},
+			now.UnixMicro(), inv.id, inv.expires.UnixMicro(), record, inv.instance, coldStart,
+			runID, int64(redisKeyTTL/time.Second),
+		).Int64Slice()
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("incrementing: %w", err)
+		}
+		if len(res) == 4 {
+			s.testsMu.Lock()
+			s.lastRun[inv.testName] = runID
+			s.testsMu.Unlock()
+			inv.runID = runID
+			return int(res[1]), int(res[2]), int(res[3]), nil
+		}
+		if attempt == incAttempts {
+			return 0, 0, 0, fmt.Errorf("incrementing: run %d was archived under each of %d attempts", runID, incAttempts)
+		}
+		runID = int(res[0])
+	}
+}
+
+// register adds the test to redisTestsKey, once per instance, and returns the run ID inc should
+// expect. It's done before incScript, so a failure can't leave a lease behind, and separately, as
+// the set is in another slot than the test's keys.
+func (s *redisStore) register(ctx context.Context, testName string) (runID int, err error) {
+	s.testsMu.Lock()
+	defer s.testsMu.Unlock()
+	if !s.registered[testName] {
+		if err := s.client.SAdd(ctx, redisTestsKey, testName).Err(); err != nil {
+			return 0, fmt.Errorf("registering test: %w", err)
+		}
+		s.registered[testName] = true
+	}
+	if runID = s.lastRun[testName]; runID == 0 {
+		runID = 1
+	}
+	return runID, nil
+}
+
+// dec releases the lease and logs the end of the invocation. Active is counted from the leases
+// when it's read, so there's no counter to update.
+func (s *redisStore) dec(ctx context.Context, inv *invocation, outcome string) error {
+	k := keysFor(inv.testName)
+	ended := k.run(inv.runID).ended
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, k.leases, inv.id)
+		pipe.HSetNX(ctx, ended, inv.id, fmt.Sprintf("%d %s", time.Now().UnixMicro(), outcome))
+		pipe.Expire(ctx, ended, redisKeyTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("decrementing: %w", err)
+	}
+	return nil
+}
+
+// archive runs archiveScript. The run's log is kept, so it can still be listed, reported on and
+// compared.
+func (s *redisStore) archive(ctx context.Context, testName string) error {
+	k := keysFor(testName)
+	err := archiveScript.Run(ctx, s.client, []string{k.counter, k.leases, k.runs},
+		time.Now().UnixMicro(), int64(redisKeyTTL/time.Second)).Err()
+	if err != nil {
+		return fmt.Errorf("archiving: %w", err)
+	}
+	return nil
+}
+
+func (s *redisStore) currentRun(ctx context.Context, testName string) (int, error) {
+	runID, err := s.client.HGet(ctx, keysFor(testName).counter, "run_id").Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, errTestNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("querying current run: %w", err)
+	}
+	return runID, nil
+}
+
+func (s *redisStore) run(ctx context.Context, testName string, runID int) (runSummary, error) {
+	k := keysFor(testName)
+	current, err := s.current(ctx, testName)
+	if err != nil && !errors.Is(err, errTestNotFound) {
+		return runSummary{}, err
+	}
+	if err == nil && current.runID == runID {
+		now := strconv.FormatInt(time.Now().UnixMicro(), 10)
+		active, err := s.client.ZCount(ctx, k.leases, now, "+inf").Result()
+		if err != nil {
+			return runSummary{}, fmt.Errorf("counting leases: %w", err)
+		}
+		current.active = int(active)
+		return current, nil
+	}
+
+	v, err := s.client.HGet(ctx, k.runs, strconv.Itoa(runID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return runSummary{}, fmt.Errorf("%w: %d", errRunNotFound, runID)
+	}
+	if err != nil {
+		return runSummary{}, fmt.Errorf("querying archived run: %w", err)
+	}
+	return parseArchivedRun(testName, runID, v)
+}
+
+// current returns the test's current run, without its active count.
+func (s *redisStore) current(ctx context.Context, testName string) (runSummary, error) {
+	vals, err := s.client.HMGet(ctx, keysFor(testName).counter, "run_id", "started", "peak", "total").Result()
+	if err != nil {
+		return runSummary{}, fmt.Errorf("querying run: %w", err)
+	}
+	if vals[0] == nil {
+		return runSummary{}, errTestNotFound
+	}
+	var n [4]int64
+	for i, v := range vals {
+		s, _ := v.(string)
+		if n[i], err = strconv.ParseInt(s, 10, 64); err != nil {
+			return runSummary{}, fmt.Errorf("parsing run: %w", err)
+		}
+	}
+	return runSummary{
+		testName: testName,
+		runID:    int(n[0]),
+		started:  time.UnixMicro(n[1]).UTC(),
+		peak:     int(n[2]),
+		total:    int(n[3]),
+	}, nil
+}
+
+func (s *redisStore) runs(ctx context.Context, testName string) ([]runSummary, error) {
+	names := []string{testName}
+	if testName == "" {
+		var err error
+		if names, err = s.client.SMembers(ctx, redisTestsKey).Result(); err != nil {
+			return nil, fmt.Errorf("querying tests: %w", err)
+		}
+	}
+	var out []runSummary
+	for _, name := range names {
+		current, err := s.current(ctx, name)
+		if errors.Is(err, errTestNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, current)
+
+		archived, err := s.client.HGetAll(ctx, keysFor(name).runs).Result()
+		if err != nil {
+			return nil, fmt.Errorf("querying archived runs: %w", err)
+		}
+		for id, v := range archived {
+			runID, err := strconv.Atoi(id)
+			if err != nil {
+				return nil, fmt.Errorf("parsing archived run %q: %w", id, err)
+			}
+			r, err := parseArchivedRun(name, runID, v)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, r)
+		}
+	}
+	sortRuns(out)
+	return out, nil
+}
+
+// parseArchivedRun parses a value of the runs hash.
+func parseArchivedRun(testName string, runID int, v string) (runSummary, error) {
+	n, err := parseInts(v, 4)
+	if err != nil {
+		return runSummary{}, fmt.Errorf("parsing archived run %d: %w", runID, err)
+	}
+	return runSummary{
+		testName:   testName,
+		runID:      runID,
+		started:    time.UnixMicro(n[0]).UTC(),
+		archivedAt: time.UnixMicro(n[1]).UTC(),
+		peak:       int(n[2]),
+		total:      int(n[3]),
+	}, nil
+}
+
+func (s *redisStore) invocations(ctx context.Context, testName string, runID int) ([]invocationRecord, error) {
+	rk := keysFor(testName).run(runID)
+	starts, err := s.client.HGetAll(ctx, rk.log).Result()
+	if err != nil {
+		return nil, fmt.Errorf("querying invocations: %w", err)
+	}
+	ends, err := s.client.HGetAll(ctx, rk.ended).Result()
+	if err != nil {
+		return nil, fmt.Errorf("querying invocation ends: %w", err)
+	}
+
+	out := make([]invocationRecord, 0, len(starts))
+	for lease, v := range starts {
+		f := strings.Fields(v)
+		if len(f) != 5 {
+			return nil, fmt.Errorf("parsing invocation %s: %q", lease, v)
+		}
+		n, err := parseInts(f[0]+" "+f[1], 2)
+		if err != nil {
+			return nil, fmt.Errorf("parsing invocation %s: %w", lease, err)
+		}
+		rec := invocationRecord{started: time.UnixMicro(n[0]).UTC(), expires: time.UnixMicro(n[1]).UTC()}
+		if end, ok := ends[lease]; ok {
+			f := strings.Fields(end)
+			if len(f) != 2 {
+				return nil, fmt.Errorf("parsing end of invocation %s: %q", lease, end)
+			}
+			ended, err := strconv.ParseInt(f[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parsing end of invocation %s: %w", lease, err)
+			}
+			rec.ended, rec.outcome = time.UnixMicro(ended).UTC(), f[1]
+		}
+		out = append(out, rec)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].started.Before(out[j].started) })
+	return out, nil
+}
+
+func (s *redisStore) instanceStats(ctx context.Context, testName string, runID int) (instanceStats, error) {
+	vals, err := s.client.HMGet(ctx, keysFor(testName).run(runID).stats, "invocations", "instances", "cold_starts").Result()
+	if err != nil {
+		return instanceStats{}, fmt.Errorf("querying instance stats: %w", err)
+	}
+	var n [3]int
+	for i, v := range vals {
+		if v == nil {
+			continue
+		}
+		s, _ := v.(string)
+		if n[i], err = strconv.Atoi(s); err != nil {
+			return instanceStats{}, fmt.Errorf("parsing instance stats: %w", err)
+		}
+	}
+	return instanceStats{invocations: n[0], instances: n[1], coldStarts: n[2]}, nil
+}
+
+// parseInts parses n space separated integers.
+func parseInts(v string, n int) ([]int64, error) {
+	f := strings.Fields(v)
+	if len(f) != n {
+		return nil, fmt.Errorf("want %d fields, got %q", n, v)
+	}
+	out := make([]int64, n)
+	for i := range f {
+		var err error
+		if out[i], err = strconv.ParseInt(f[i], 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// redisErrorCode returns the error prefix of a Redis error reply, e.g. WRONGTYPE, or "" if err
+// isn't one.
+func redisErrorCode(err error) string {
+	var rErr redis.Error
+	if !errors.As(err, &rErr) || errors.Is(err, redis.Nil) {
+		return ""
+	}
+	return strings.SplitN(rErr.Error(), " ", 2)[0]
+}
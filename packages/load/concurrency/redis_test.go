@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// redisTestStore opens a store on the Redis server in REDIS_URL, skipping the test without one.
+// Tests use a test name of their own, whose keys are deleted when the test ends.
+func redisTestStore(t *testing.T) (*redisStore, string) {
+	t.Helper()
+	raw := os.Getenv("REDIS_URL")
+	if raw == "" {
+		t.Skip("REDIS_URL isn't set")
+	}
+	st, err := openRedis(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := st.(*redisStore)
+	rs.setPool(poolConfig{maxOpenConns: 4})
+	ctx := context.Background()
+	if err := rs.client.Ping(ctx).Err(); err != nil {
+		rs.close()
+		t.Skipf("Redis isn't available: %v", err)
+	}
+	testName := "test_" + randomID(4)
+	t.Cleanup(func() {
+		if keys, err := rs.client.Keys(ctx, keysFor(testName).counter+"*").Result(); err == nil && len(keys) > 0 {
+			rs.client.Del(ctx, keys...)
+		}
+		rs.client.SRem(ctx, redisTestsKey, testName)
+		rs.close()
+	})
+	if err := rs.ensureSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return rs, testName
+}
+
+func TestRedisRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	st, testName := redisTestStore(t)
+
+	first, second := testInvocation(testName, 0), testInvocation(testName, 0)
+	second.seq = 2
+	for i, inv := range []*invocation{first, second} {
+		active, peak, total, err := st.inc(ctx, inv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if active != i+1 || peak != i+1 || total != i+1 || inv.runID != 1 {
+			t.Errorf("inc %d = active %d, peak %d, total %d, run %d", i+1, active, peak, total, inv.runID)
+		}
+	}
+	if err := st.dec(ctx, first, "ok"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := st.run(ctx, testName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.active != 1 || r.peak != 2 || r.total != 2 || !r.archivedAt.IsZero() {
+		t.Errorf("current run = %+v, want 1 active, peak 2, total 2", r)
+	}
+
+	if err := st.archive(ctx, testName); err != nil {
+		t.Fatal(err)
+	}
+	if runID, err := st.currentRun(ctx, testName); err != nil || runID != 2 {
+		t.Errorf("current run = %d, %v; want 2", runID, err)
+	}
+	if r, err = st.run(ctx, testName, 1); err != nil {
+		t.Fatal(err)
+	}
+	if r.peak != 2 || r.total != 2 || r.archivedAt.IsZero() {
+		t.Errorf("archived run = %+v, want peak 2, total 2", r)
+	}
+	runs, err := st.runs(ctx, testName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Errorf("got %d runs, want 2", len(runs))
+	}
+
+	// The run's log outlives the archive, and the second invocation can still end in it.
+	if err := st.dec(ctx, second, "error"); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := st.invocations(ctx, testName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("got %d invocations, want 2", len(recs))
+	}
+	outcomes := map[string]bool{}
+	for _, rec := range recs {
+		outcomes[rec.outcome] = true
+	}
+	if !outcomes["ok"] || !outcomes["error"] {
+		t.Errorf("outcomes = %v, want ok and error", outcomes)
+	}
+	stats, err := st.instanceStats(ctx, testName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (instanceStats{invocations: 2, instances: 1, coldStarts: 1}); stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+	if r, err = st.run(ctx, testName, 2); err != nil || r.total != 0 || r.active != 0 {
+		t.Errorf("new run = %+v, %v; want an empty run", r, err)
+	}
+}
+
+func TestRedisReapsExpiredLeases(t *testing.T) {
+	ctx := context.Background()
+	st, testName := redisTestStore(t)
+
+	killed := testInvocation(testName, 0)
+	killed.expires = time.Now().Add(50 * time.Millisecond)
+	if _, _, _, err := st.inc(ctx, killed); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if r, err := st.run(ctx, testName, 1); err != nil || r.active != 0 {
+		t.Errorf("run = %+v, %v; want no active invocations once the lease expired", r, err)
+	}
+	active, peak, total, err := st.inc(ctx, testInvocation(testName, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active != 1 || peak != 1 || total != 2 {
+		t.Errorf("inc = active %d, peak %d, total %d; want the expired lease reaped", active, peak, total)
+	}
+	if n, err := st.client.ZCard(ctx, keysFor(testName).leases).Result(); err != nil || n != 1 {
+		t.Errorf("%d leases, %v; want 1", n, err)
+	}
+}
+
+// TestRedisRunKeys checks run 1's log doesn't pick up run 11's, whose keys share its leading
+// digit.
+func TestRedisRunKeys(t *testing.T) {
+	ctx := context.Background()
+	st, testName := redisTestStore(t)
+
+	if _, _, _, err := st.inc(ctx, testInvocation(testName, 0)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := st.archive(ctx, testName); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		inv := testInvocation(testName, 0)
+		if _, _, _, err := st.inc(ctx, inv); err != nil {
+			t.Fatal(err)
+		}
+		if inv.runID != 11 {
+			t.Fatalf("run = %d, want 11", inv.runID)
+		}
+	}
+	for runID, want := range map[int]int{1: 1, 11: 2, 2: 0} {
+		recs, err := st.invocations(ctx, testName, runID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recs) != want {
+			t.Errorf("run %d has %d invocations, want %d", runID, len(recs), want)
+		}
+	}
+}
+
+// TestRedisKeysExpire checks every key an invocation, its end and an archive write is set to
+// expire, so abandoned tests and old runs don't pile up.
+func TestRedisKeysExpire(t *testing.T) {
+	ctx := context.Background()
+	st, testName := redisTestStore(t)
+
+	inv := testInvocation(testName, 0)
+	if _, _, _, err := st.inc(ctx, inv); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.dec(ctx, inv, "ok"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := st.inc(ctx, testInvocation(testName, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.archive(ctx, testName); err != nil {
+		t.Fatal(err)
+	}
+	if registered, err := st.client.SIsMember(ctx, redisTestsKey, testName).Result(); err != nil || !registered {
+		t.Errorf("test registered = %t, %v; want true", registered, err)
+	}
+
+	k, rk := keysFor(testName), keysFor(testName).run(1)
+	for _, key := range []string{k.counter, k.runs, rk.log, rk.ended, rk.instances, rk.stats} {
+		ttl, err := st.client.TTL(ctx, key).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl <= 0 || ttl > redisKeyTTL {
+			t.Errorf("%s has TTL %s, want one up to %s", key, ttl, redisKeyTTL)
+		}
+	}
+}
+
+// TestRedisArchivedUnderInc archives a test from another instance, and checks the instance which
+// last saw the old run logs its next invocation in the new one.
+func TestRedisArchivedUnderInc(t *testing.T) {
+	ctx := context.Background()
+	st, testName := redisTestStore(t)
+	other := &redisStore{opts: st.opts, registered: map[string]bool{}, lastRun: map[string]int{}}
+	other.setPool(poolConfig{maxOpenConns: 1})
+	defer other.close()
+
+	if _, _, _, err := st.inc(ctx, testInvocation(testName, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.archive(ctx, testName); err != nil {
+		t.Fatal(err)
+	}
+	inv := testInvocation(testName, 0)
+	active, _, total, err := st.inc(ctx, inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.runID != 2 || active != 1 || total != 1 {
+		t.Errorf("inc = run %d, active %d, total %d; want the first invocation of run 2", inv.runID, active, total)
+	}
+	if recs, err := st.invocations(ctx, testName, 1); err != nil || len(recs) != 1 {
+		t.Errorf("run 1 has %d invocations, %v; want only the one before the archive", len(recs), err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// report summarizes a run of a test without perturbing it.
+type report struct {
+	testName string
+	runID    int
+	archived bool
+	active   int
+	// peak is the live estimate: the most active invocations any inc observed. It misses
+	// overlaps inc couldn't see, e.g. concurrent sharded invocations, and invocations killed
+	// before dec keep counting until their lease expires.
+	peak  int
+	total int
+
+	// The remainder are derived from the invocation log, and are zero if it's empty.
+	invocations int
+	completed   int
+	errors      int
+	first, last time.Time
+	// exactPeak is the peak reconstructed from the logged intervals, first reached at
+	// exactPeakAt.
+	exactPeak   int
+	exactPeakAt time.Time
+	// latencies maps percentiles to the latency of completed invocations.
+	latencies map[int]time.Duration
+}
+
+// reportPercentiles are the latency percentiles included in a report.
+var reportPercentiles = []int{50, 90, 95, 99}
+
+// throughput is the rate of invocations between the first start and the last end.
+func (r *report) throughput() float64 {
+	d := r.last.Sub(r.first).Seconds()
+	if d <= 0 {
+		return 0
+	}
+	return float64(r.invocations) / d
+}
+
+// getReport builds the report for a run of the test. Archived runs have no active invocations.
+func getReport(ctx context.Context, st store, testName string, runID int) (*report, error) {
+	sum, err := st.run(ctx, testName, runID)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := st.invocations(ctx, testName, runID)
+	if err != nil {
+		return nil, err
+	}
+	r := &report{
+		testName:    testName,
+		runID:       runID,
+		archived:    !sum.archivedAt.IsZero(),
+		active:      sum.active,
+		peak:        sum.peak,
+		total:       sum.total,
+		invocations: len(recs),
+	}
+
+	var latencies []time.Duration
+	ivs := intervals(recs, time.Now())
+	r.exactPeak, r.exactPeakAt = peakConcurrency(ivs)
+	for _, iv := range ivs {
+		if r.first.IsZero() || iv.start.Before(r.first) {
+			r.first = iv.start
+		}
+		if iv.end.After(r.last) {
+			r.last = iv.end
+		}
+	}
+	for _, rec := range recs {
+		if rec.outcome != "" && rec.outcome != outcomeOK {
+			r.errors++
+		}
+		if !rec.ended.IsZero() {
+			latencies = append(latencies, rec.ended.Sub(rec.started))
+		}
+	}
+	r.completed = len(latencies)
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		r.latencies = map[int]time.Duration{}
+		for _, p := range reportPercentiles {
+			r.latencies[p] = percentile(latencies, p)
+		}
+	}
+	return r, nil
+}
+
+// percentile interpolates the pth percentile of the sorted durations, as Postgres'
+// percentile_cont does. It's 0 if there are none.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := float64(p) / 100 * float64(len(sorted)-1)
+	i := int(pos)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	frac := pos - float64(i)
+	return sorted[i] + time.Duration(frac*float64(sorted[i+1]-sorted[i]))
+}
+
+// formatTime formats t for a report, leaving zero times blank.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// respondReport responds to mode=report, describing the run selected by the `run` arg, or the
+// current run.
+func respondReport(ctx context.Context, st store, testName string, args map[string]interface{}) map[string]interface{} {
+	runID, err := resolveRun(ctx, st, testName, args, "run")
+	if err != nil {
+		return wrapRunErr(args, err)
+	}
+	r, err := getReport(ctx, st, testName, runID)
+	if err != nil {
+		return wrapRunErr(args, err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "testname=%s\nrun=%d\narchived=%t\n", r.testName, r.runID, r.archived)
+	fmt.Fprintf(&b, "active=%d\npeak=%d\npeak_exact=%d\npeak_exact_at=%s\ntotal=%d\n",
+		r.active, r.peak, r.exactPeak, formatTime(r.exactPeakAt), r.total)
+	fmt.Fprintf(&b, "invocations=%d\ncompleted=%d\nerrors=%d\n", r.invocations, r.completed, r.errors)
+	fmt.Fprintf(&b, "first=%s\nlast=%s\nthroughput=%.3f/s", formatTime(r.first), formatTime(r.last), r.throughput())
+	latencies := map[string]interface{}{}
+	for _, p := range reportPercentiles {
+		if l, ok := r.latencies[p]; ok {
+			fmt.Fprintf(&b, "\np%d=%s", p, l)
+			latencies[fmt.Sprintf("p%d_ms", p)] = float64(l) / float64(time.Millisecond)
+		}
+	}
+
+	return respond(args, http.StatusOK, b.String(), map[string]interface{}{
+		"test_name":     r.testName,
+		"run":           r.runID,
+		"archived":      r.archived,
+		"active":        r.active,
+		"peak":          r.peak,
+		"peak_exact":    r.exactPeak,
+		"peak_exact_at": formatTime(r.exactPeakAt),
+		"total":         r.total,
+		"invocations":   r.invocations,
+		"completed":     r.completed,
+		"errors":        r.errors,
+		"first":         formatTime(r.first),
+		"last":          formatTime(r.last),
+		"throughput":    r.throughput(),
+		"latency":       latencies,
+	})
+}
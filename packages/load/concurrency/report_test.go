@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	ms := func(ds ...int) []time.Duration {
+		out := make([]time.Duration, len(ds))
+		for i, d := range ds {
+			out[i] = time.Duration(d) * time.Millisecond
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		name   string
+		sorted []time.Duration
+		p      int
+		want   time.Duration
+	}{
+		{name: "no samples", p: 50, want: 0},
+		{name: "one sample p0", sorted: ms(7), p: 0, want: 7 * time.Millisecond},
+		{name: "one sample p50", sorted: ms(7), p: 50, want: 7 * time.Millisecond},
+		{name: "one sample p100", sorted: ms(7), p: 100, want: 7 * time.Millisecond},
+		{name: "p0", sorted: ms(10, 20, 30), p: 0, want: 10 * time.Millisecond},
+		{name: "p50", sorted: ms(10, 20, 30), p: 50, want: 20 * time.Millisecond},
+		{name: "interpolated", sorted: ms(10, 20), p: 90, want: 19 * time.Millisecond},
+		{name: "p100", sorted: ms(10, 20, 30), p: 100, want: 30 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := percentile(tc.sorted, tc.p); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestThroughput(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name string
+		r    report
+		want float64
+	}{
+		{name: "empty", want: 0},
+		{name: "zero duration", r: report{invocations: 3, first: now, last: now}, want: 0},
+		{name: "two seconds", r: report{invocations: 3, first: now, last: now.Add(2 * time.Second)}, want: 1.5},
+	} {
+		if got := tc.r.throughput(); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestReport reports on a run whose only invocation hasn't ended, and so has no latencies.
+func TestReport(t *testing.T) {
+	ctx := context.Background()
+	st := sqliteTestStore(t)
+	if err := st.ensureSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	args := map[string]interface{}{"format": "json"}
+	if out := respondReport(ctx, st, "report", args); out["statusCode"] != http.StatusNotFound {
+		t.Fatalf("report on an unknown test: status %v, want 404", out["statusCode"])
+	}
+	if _, _, _, err := st.inc(ctx, testInvocation("report", 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	out := respondReport(ctx, st, "report", args)
+	if out["statusCode"] != http.StatusOK {
+		t.Fatalf("status %v: %v", out["statusCode"], out["body"])
+	}
+	body := out["body"].(map[string]interface{})
+	for k, want := range map[string]interface{}{
+		"run":         1,
+		"active":      1,
+		"peak":        1,
+		"peak_exact":  1,
+		"total":       1,
+		"invocations": 1,
+		"completed":   0,
+		"errors":      0,
+	} {
+		if body[k] != want {
+			t.Errorf("%s = %v, want %v", k, body[k], want)
+		}
+	}
+	if latency := body["latency"].(map[string]interface{}); len(latency) != 0 {
+		t.Errorf("latency = %v, want none without a completed invocation", latency)
+	}
+
+	args["run"] = "2"
+	if out := respondReport(ctx, st, "report", args); out["statusCode"] != http.StatusNotFound {
+		t.Errorf("report on an unknown run: status %v, want 404", out["statusCode"])
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runSummary describes a run's counters.
+type runSummary struct {
+	testName string
+	runID    int
+	started  time.Time
+	// archivedAt is zero for the current run.
+	archivedAt time.Time
+	// active is only counted for the current run, and only by store.run.
+	active int
+	peak   int
+	total  int
+}
+
+// metricDiff is a metric of two runs being compared.
+type metricDiff struct {
+	name      string
+	base, run float64
+}
+
+// resolveRun returns the run ID given in args[key], defaulting to the test's current run.
+func resolveRun(ctx context.Context, st store, testName string, args map[string]interface{}, key string) (int, error) {
+	runID, err := intSetting(args, key, "", 0)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errInvalidArg, err)
+	}
+	current, err := st.currentRun(ctx, testName)
+	if err != nil {
+		return 0, err
+	}
+	if runID == 0 {
+		return current, nil
+	}
+	if runID < 1 || runID > current {
+		return 0, fmt.Errorf("%w: %d", errRunNotFound, runID)
+	}
+	return runID, nil
+}
+
+// wrapRunErr responds with an error from resolving or reporting on a run.
+func wrapRunErr(args map[string]interface{}, err error) map[string]interface{} {
+	switch {
+	case errors.Is(err, errInvalidArg):
+		return wrapErr(args, http.StatusBadRequest, err)
+	case errors.Is(err, errTestNotFound), errors.Is(err, errRunNotFound):
+		return wrapErr(args, http.StatusNotFound, err)
+	}
+	return wrapErr(args, http.StatusInternalServerError, err)
+}
+
+// sortRuns orders runs by test and run ID.
+func sortRuns(runs []runSummary) {
+	sort.Slice(runs, func(i, j int) bool {
+		if runs[i].testName != runs[j].testName {
+			return runs[i].testName < runs[j].testName
+		}
+		return runs[i].runID < runs[j].runID
+	})
+}
+
+// listRuns responds to mode=list, enumerating the runs of every test, or of `testname` if given.
+func listRuns(ctx context.Context, st store, args map[string]interface{}) map[string]interface{} {
+	testName, _ := args["testname"].(string)
+	runs, err := st.runs(ctx, testName)
+	if err != nil {
+		return wrapErr(args, http.StatusInternalServerError, err, "listing runs")
+	}
+	var b strings.Builder
+	out := []map[string]interface{}{}
+	for _, r := range runs {
+		fmt.Fprintf(&b, "testname=%s run=%d started=%s archived=%s peak=%d total=%d\n",
+			r.testName, r.runID, formatTime(r.started), formatTime(r.archivedAt), r.peak, r.total)
+		out = append(out, map[string]interface{}{
+			"test_name": r.testName,
+			"run":       r.runID,
+			"started":   formatTime(r.started),
+			"archived":  formatTime(r.archivedAt),
+			"peak":      r.peak,
+			"total":     r.total,
+		})
+	}
+	return respond(args, http.StatusOK, b.String(), map[string]interface{}{"runs": out})
+}
+
+// compareRuns responds to mode=compare, diffing the `run` arg (default the current run) against the
+// `base` arg (default the run before it).
+func compareRuns(ctx context.Context, st store, testName string, args map[string]interface{}) map[string]interface{} {
+	runID, err := resolveRun(ctx, st, testName, args, "run")
+	if err != nil {
+		return wrapRunErr(args, err)
+	}
+	baseID, err := intSetting(args, "base", "", runID-1)
+	if err != nil {
+		return wrapRunErr(args, fmt.Errorf("%w: %v", errInvalidArg, err))
+	}
+	if baseID < 1 {
+		return wrapRunErr(args, fmt.Errorf("%w: run %d has no previous run to compare with", errRunNotFound, runID))
+	}
+
+	run, err := getReport(ctx, st, testName, runID)
+	if err != nil {
+		return wrapRunErr(args, err)
+	}
+	base, err := getReport(ctx, st, testName, baseID)
+	if err != nil {
+		return wrapRunErr(args, err)
+	}
+
+	metrics := []metricDiff{
+		{"peak", float64(base.peak), float64(run.peak)},
+		{"peak_exact", float64(base.exactPeak), float64(run.exactPeak)},
+		{"total", float64(base.total), float64(run.total)},
+		{"throughput", base.throughput(), run.throughput()},
+		{"errors", float64(base.errors), float64(run.errors)},
+	}
+	for _, p := range reportPercentiles {
+		metrics = append(metrics, metricDiff{
+			fmt.Sprintf("p%d_ms", p),
+			float64(base.latencies[p]) / float64(time.Millisecond),
+			float64(run.latencies[p]) / float64(time.Millisecond),
+		})
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "testname=%s base=%d run=%d\n", testName, baseID, runID)
+	diff := map[string]interface{}{}
+	for _, m := range metrics {
+		fmt.Fprintf(&b, "%s: base=%.3f run=%.3f delta=%+.3f\n", m.name, m.base, m.run, m.run-m.base)
+		diff[m.name] = map[string]interface{}{
+			"base":  m.base,
+			"run":   m.run,
+			"delta": m.run - m.base,
+		}
+	}
+	return respond(args, http.StatusOK, b.String(), map[string]interface{}{
+		"test_name": testName,
+		"base":      baseID,
+		"run":       runID,
+		"metrics":   diff,
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// TestRuns resets a test through Main, which archives its first run, then lists and compares the
+// two runs.
+func TestRuns(t *testing.T) {
+	sqliteTestDatabase(t)
+	call := func(args map[string]interface{}) (int, map[string]interface{}) {
+		t.Helper()
+		if _, ok := args["testname"]; !ok {
+			args["testname"] = "runs"
+		}
+		args["format"] = "json"
+		out := Main(args)
+		body, _ := out["body"].(map[string]interface{})
+		return out["statusCode"].(int), body
+	}
+	for i, args := range []map[string]interface{}{{}, {}, {"reset": "true"}} {
+		if status, body := call(args); status != http.StatusOK {
+			t.Fatalf("call %d: status %d: %v", i+1, status, body)
+		}
+	}
+
+	status, body := call(map[string]interface{}{"mode": "list"})
+	if status != http.StatusOK {
+		t.Fatalf("list: status %d: %v", status, body)
+	}
+	var runs [][3]interface{}
+	for _, r := range body["runs"].([]map[string]interface{}) {
+		runs = append(runs, [3]interface{}{r["run"], r["total"], r["archived"] != ""})
+	}
+	if want := [][3]interface{}{{1, 2, true}, {2, 1, false}}; !reflect.DeepEqual(runs, want) {
+		t.Errorf("runs (id, total, archived) = %v, want %v", runs, want)
+	}
+
+	status, body = call(map[string]interface{}{"mode": "compare"})
+	if status != http.StatusOK {
+		t.Fatalf("compare: status %d: %v", status, body)
+	}
+	if body["base"] != 1 || body["run"] != 2 {
+		t.Errorf("compared run %v with %v, want 2 with 1", body["run"], body["base"])
+	}
+	total := body["metrics"].(map[string]interface{})["total"].(map[string]interface{})
+	if total["base"] != 2.0 || total["run"] != 1.0 || total["delta"] != -1.0 {
+		t.Errorf("total = %v, want 2 -> 1", total)
+	}
+
+	for _, tc := range []struct {
+		args   map[string]interface{}
+		status int
+	}{
+		{map[string]interface{}{"mode": "compare", "run": "3"}, http.StatusNotFound},
+		{map[string]interface{}{"mode": "compare", "base": "9"}, http.StatusNotFound},
+		{map[string]interface{}{"mode": "compare", "run": "1"}, http.StatusNotFound},
+		{map[string]interface{}{"mode": "compare", "run": "x"}, http.StatusBadRequest},
+		{map[string]interface{}{"mode": "compare", "testname": "unknown"}, http.StatusNotFound},
+	} {
+		if status, body := call(tc.args); status != tc.status {
+			t.Errorf("%v: status %d, want %d: %v", tc.args, status, tc.status, body)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/xo/dburl"
+
+	// Registers the sqlite3 driver. It's a cgo package, so SQLite stores need CGO_ENABLED=1 and
+	// a C compiler at build time; see sqliteAvailable.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteBusyTimeout is how long, in milliseconds, a connection waits for another writer.
+const sqliteBusyTimeout = "5000"
+
+// sqliteDialect supports an embedded database, e.g. sqlite:/tmp/concurrency.db. Every instance
+// has its own filesystem, so it's only meaningful for local runs or a single instance. The driver
+// needs cgo, which the platform's Go runtime may not build with.
+var sqliteDialect = &dialect{
+	name:          "sqlite3",
+	questionMarks: true,
+	migrations:    sqliteMigrations,
+	schemaVersionTable: `
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version      integer NOT NULL,
+		applied_at   datetime NOT NULL,
+		PRIMARY KEY (version)
+	);
+	`,
+	upsertCounter: `
+	INSERT INTO concurrency (test_name, con_active, con_peak, con_total, run_id, run_started_at)
+		VALUES ($1, 0, 0, 1, 1, $2)
+		ON CONFLICT (test_name)
+		DO UPDATE SET 
+			con_total = con_total + 1
+	`,
+	insertCounter: `
+	INSERT INTO concurrency (test_name, con_active, con_peak, con_total, run_id, run_started_at)
+		VALUES ($1, 0, 0, 0, 1, $2)
+		ON CONFLICT (test_name) DO NOTHING
+	`,
+	upsertShard: `
+	INSERT INTO concurrency_shard (test_name, shard, con_peak, con_total)
+		VALUES ($1, $2, 0, 1)
+		ON CONFLICT (test_name, shard)
+		DO UPDATE SET
+			con_total = con_total + 1
+	`,
+	// Transactions are opened with _txlock=immediate, so a writer already excludes archive.
+	shareCounter: `SELECT run_id FROM concurrency WHERE test_name = $1`,
+	lockMigrations: func(ctx context.Context, conn *sql.Conn) (func() error, error) {
+		// Transactions are opened with _txlock=immediate, so migrate's already holds the write
+		// lock.
+		return func() error { return nil }, nil
+	},
+	// The driver's error type, and so its error codes, are only available with cgo, so SQLite
+	// errors are reported without a code.
+	errorCode: func(err error) string {
+		return ""
+	},
+	// For the same reason, errors are matched on their text.
+	retryable: func(err error) (retry, remigrate bool) {
+		msg := err.Error()
+		switch {
+		case strings.Contains(msg, "no such table"), strings.Contains(msg, "no such column"):
+			return false, true
+		case strings.Contains(msg, "database is locked"), strings.Contains(msg, "database table is locked"):
+			return true, false
+		}
+		return false, false
+	},
+}
+
+// sqliteMigrations are the SQLite schema's history.
+var sqliteMigrations = []string{
+	`
+	CREATE TABLE IF NOT EXISTS concurrency (
+		test_name       varchar(40) NOT NULL,
+		con_active      integer NOT NULL,
+		con_peak        integer NOT NULL,
+		con_total       integer NOT NULL,
+		run_id          integer NOT NULL DEFAULT 1,
+		run_started_at  datetime NOT NULL,
+		PRIMARY KEY (test_name)
+	);
+	CREATE TABLE IF NOT EXISTS concurrency_lease (
+		lease_id     varchar(64) NOT NULL,
+		test_name    varchar(40) NOT NULL,
+		expires_at   datetime NOT NULL,
+		PRIMARY KEY (lease_id)
+	);
+	CREATE INDEX IF NOT EXISTS concurrency_lease_test_name_expires_at
+		ON concurrency_lease (test_name, expires_at);
+	CREATE TABLE IF NOT EXISTS concurrency_invocation (
+		id             integer PRIMARY KEY AUTOINCREMENT,
+		activation_id  varchar(64) NOT NULL,
+		test_name      varchar(40) NOT NULL,
+		run_id         integer NOT NULL DEFAULT 1,
+		wait_ms        bigint NOT NULL,
+		started_at     datetime NOT NULL,
+		expires_at     datetime NOT NULL,
+		instance_id    varchar(32) NOT NULL DEFAULT '',
+		instance_seq   bigint NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS concurrency_invocation_test_name_run_id_started_at
+		ON concurrency_invocation (test_name, run_id, started_at);
+	CREATE TABLE IF NOT EXISTS concurrency_invocation_end (
+		activation_id  varchar(64) NOT NULL,
+		test_name      varchar(40) NOT NULL,
+		ended_at       datetime NOT NULL,
+		outcome        varchar(16) NOT NULL,
+		PRIMARY KEY (activation_id)
+	);
+	CREATE TABLE IF NOT EXISTS concurrency_run (
+		test_name    varchar(40) NOT NULL,
+		run_id       integer NOT NULL,
+		started_at   datetime NOT NULL,
+		archived_at  datetime NOT NULL,
+		con_peak     integer NOT NULL,
+		con_total    integer NOT NULL,
+		PRIMARY KEY (test_name, run_id)
+	);
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS concurrency_shard (
+		test_name    varchar(40) NOT NULL,
+		shard        integer NOT NULL,
+		con_peak     integer NOT NULL,
+		con_total    integer NOT NULL,
+		PRIMARY KEY (test_name, shard)
+	);
+	`,
+}
+
+// openSQLite opens a SQLite store. Transactions take the write lock up front, and wait for other
+// writers rather than failing immediately.
+func openSQLite(u *dburl.URL) (store, error) {
+	if !sqliteAvailable {
+		return nil, fmt.Errorf("DATABASE_URL scheme %q needs the action built with cgo (CGO_ENABLED=1)", u.OriginalScheme)
+	}
+	q := u.Query()
+	if q.Get("_txlock") == "" {
+		q.Set("_txlock", "immediate")
+	}
+	if q.Get("_busy_timeout") == "" {
+		q.Set("_busy_timeout", sqliteBusyTimeout)
+	}
+	dsn := u.Opaque
+	if dsn == "" {
+		dsn = u.Path
+	}
+	db, err := sql.Open("sqlite3", dsn+"?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStore{db: db, d: sqliteDialect}, nil
+}
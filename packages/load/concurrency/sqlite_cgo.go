@@ -0,0 +1,6 @@
+//go:build cgo
+
+package main
+
+// sqliteAvailable reports whether the sqlite3 driver works in this build.
+const sqliteAvailable = true
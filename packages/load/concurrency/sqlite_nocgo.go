@@ -0,0 +1,7 @@
+//go:build !cgo
+
+package main
+
+// sqliteAvailable reports whether the sqlite3 driver works in this build. Without cgo it's
+// only a stub which fails every query.
+const sqliteAvailable = false
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxIncAttempts bounds the attempts inc makes, waiting a multiple of incRetryBackoff between
+// each.
+const (
+	maxIncAttempts  = 3
+	incRetryBackoff = 50 * time.Millisecond
+)
+
+// dialect captures what differs between the SQL databases sqlStore supports. Queries are written
+// with Postgres style $n placeholders and times are passed from Go, so the rest can be shared.
+type dialect struct {
+	name string
+	// questionMarks is set if the driver wants ? placeholders rather than $n.
+	questionMarks bool
+	// migrations bring the schema to version i+1. Never edit a migration which has shipped,
+	// append a new one.
+	migrations []string
+	// schemaVersionTable creates the schema_version table if it doesn't exist.
+	schemaVersionTable string
+	// upsertCounter inserts the counter row for test $1, starting run 1 at $2, or bumps its total.
+	upsertCounter string
+	// insertCounter inserts the counter row for test $1, starting run 1 at $2, if it's missing.
+	insertCounter string
+	// upsertShard inserts shard $2 of test $1 with a total of 1, or bumps its total.
+	upsertShard string
+	// shareCounter selects the run_id of test $1's counter row, holding a shared lock on it for the
+	// rest of the transaction.
+	shareCounter string
+	// lockMigrations serializes migrations across instances, taking a lock held by conn's session
+	// until unlock is called. migrate calls unlock after its transaction on conn has ended.
+	lockMigrations func(ctx context.Context, conn *sql.Conn) (unlock func() error, err error)
+	// errorCode returns the driver's code for err, or "" if err isn't from this driver.
+	errorCode func(err error) string
+	// retryable reports whether an inc which failed with err should be retried, and whether the
+	// schema must be migrated first because it has gone missing.
+	retryable func(err error) (retry, remigrate bool)
+}
+
+// dialects are the supported SQL dialects, keyed by dburl driver.
+var dialects = map[string]*dialect{
+	"postgres": postgresDialect,
+	"mysql":    mysqlDialect,
+	"sqlite3":  sqliteDialect,
+}
+
+// sqlStore is a store backed by a SQL database.
+type sqlStore struct {
+	db *sql.DB
+	d  *dialect
+
+	schemaMu sync.Mutex
+	// schemaReady is set once this instance has migrated the schema.
+	schemaReady bool
+}
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+var placeholder = regexp.MustCompile(`\$[0-9]+`)
+
+// bind rewrites $n placeholders for the dialect, repeating args which are referenced more than
+// once.
+func (s *sqlStore) bind(query string, args []interface{}) (string, []interface{}) {
+	if !s.d.questionMarks {
+		return query, args
+	}
+	var bound []interface{}
+	query = placeholder.ReplaceAllStringFunc(query, func(p string) string {
+		n, _ := strconv.Atoi(p[1:])
+		bound = append(bound, args[n-1])
+		return "?"
+	})
+	return query, bound
+}
+
+func (s *sqlStore) exec(ctx context.Context, q querier, query string, args ...interface{}) (sql.Result, error) {
+	query, args = s.bind(query, args)
+	return q.ExecContext(ctx, query, args...)
+}
+
+func (s *sqlStore) query(ctx context.Context, q querier, query string, args ...interface{}) (*sql.Rows, error) {
+	query, args = s.bind(query, args)
+	return q.QueryContext(ctx, query, args...)
+}
+
+func (s *sqlStore) queryRow(ctx context.Context, q querier, query string, args ...interface{}) *sql.Row {
+	query, args = s.bind(query, args)
+	return q.QueryRowContext(ctx, query, args...)
+}
+
+func (s *sqlStore) setPool(cfg poolConfig) {
+	s.db.SetMaxOpenConns(cfg.maxOpenConns)
+	s.db.SetMaxIdleConns(cfg.maxIdleConns)
+	s.db.SetConnMaxLifetime(cfg.connMaxLifetime)
+}
+
+func (s *sqlStore) close() error {
+	return s.db.Close()
+}
+
+// inc calls incOnce, retrying transient failures. If the schema has gone missing since this
+// instance migrated it, e.g. the tables were dropped between test runs, it's migrated again first.
+func (s *sqlStore) inc(ctx context.Context, inv *invocation) (active, peak, total int, err error) {
+	for attempt := 1; ; attempt++ {
+		active, peak, total, err = s.incOnce(ctx, inv)
+		if err == nil || attempt == maxIncAttempts {
+			return
+		}
+		retry, remigrate := s.d.retryable(err)
+		switch {
+		case remigrate:
+			if err = s.repairSchema(ctx); err != nil {
+				return 0, 0, 0, err
+			}
+		case retry:
+			if sleep(ctx, time.Duration(attempt)*incRetryBackoff) != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// incOnce implements inc. Active is the number of unexpired leases, so invocations which were
+// killed before they could call dec() stop counting once their lease expires. Expired leases are
+// reaped along the way.
+func (s *sqlStore) incOnce(ctx context.Context, inv *invocation) (active, peak, total int, err error) {
+	if inv.shard != 0 {
+		return s.incSharded(ctx, inv)
+	}
+	now := time.Now().UTC()
+	testName := inv.testName
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Upserting the counter row first takes its row lock, serializing the lease count below
+	// with other invocations of the same test.
+	if _, err = s.exec(ctx, tx, s.d.upsertCounter, testName, now); err != nil {
+		return 0, 0, 0, fmt.Errorf("inserting: %w", err)
+	}
+	err = s.queryRow(ctx, tx, `
+	SELECT con_total, run_id FROM concurrency WHERE test_name = $1
+	`, testName).Scan(&total, &inv.runID)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("inserting: %w", err)
+	}
+	if err = s.reapLeases(ctx, tx, testName, now); err != nil {
+		return 0, 0, 0, err
+	}
+	_, err = s.exec(ctx, tx, `
+	INSERT INTO concurrency_lease (lease_id, test_name, expires_at) VALUES ($1, $2, $3)
+	`, inv.id, testName, inv.expires.UTC())
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("inserting lease: %w", err)
+	}
+	if err = s.logStart(ctx, tx, inv, now); err != nil {
+		return 0, 0, 0, err
+	}
+	if active, peak, err = s.updateActive(ctx, tx, testName, now); err != nil {
+		return 0, 0, 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, 0, 0, fmt.Errorf("committing: %w", err)
+	}
+	return
+}
+
+// incSharded is incOnce for a sharded counter. Only the invocation's shard row is locked
+// exclusively, so invocations of the test contend on one of many rows rather than all on the
+// counter row, which they only hold a shared lock on, for the run ID. The price is that active is
+// counted without serializing against other invocations, so the peak each records in its shard is
+// a lower bound; the report's figures from the invocation log are exact. Expired leases are left
+// for an unsharded inc or archive to reap, as reaping them here would make every invocation
+// contend on the same rows again.
+func (s *sqlStore) incSharded(ctx context.Context, inv *invocation) (active, peak, total int, err error) {
+	now := time.Now().UTC()
+	testName := inv.testName
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The counter row is locked before the shard row, in the order archive locks them.
+	if inv.runID, err = s.runForShard(ctx, tx, testName, now); err != nil {
+		return 0, 0, 0, err
+	}
+	if _, err = s.exec(ctx, tx, s.d.upsertShard, testName, inv.shard); err != nil {
+		return 0, 0, 0, fmt.Errorf("inserting shard: %w", err)
+	}
+	_, err = s.exec(ctx, tx, `
+	INSERT INTO concurrency_lease (lease_id, test_name, expires_at) VALUES ($1, $2, $3)
+	`, inv.id, testName, inv.expires.UTC())
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("inserting lease: %w", err)
+	}
+	if err = s.logStart(ctx, tx, inv, now); err != nil {
+		return 0, 0, 0, err
+	}
+	err = s.queryRow(ctx, tx, `
+	SELECT count(*) FROM concurrency_lease WHERE test_name = $1 AND expires_at >= $2
+	`, testName, now).Scan(&active)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("counting leases: %w", err)
+	}
+	_, err = s.exec(ctx, tx, `
+	UPDATE concurrency_shard SET
+		con_peak = CASE WHEN con_peak < $3 THEN $3 ELSE con_peak END
+	WHERE test_name = $1 AND shard = $2
+	`, testName, inv.shard, active)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("updating shard peak: %w", err)
+	}
+	r := runSummary{testName: testName}
+	err = s.queryRow(ctx, tx, `
+	SELECT con_peak, con_total FROM concurrency WHERE test_name = $1
+	`, testName).Scan(&r.peak, &r.total)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("querying counter: %w", err)
+	}
+	if err = s.addShards(ctx, tx, &r); err != nil {
+		return 0, 0, 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, 0, 0, fmt.Errorf("committing: %w", err)
+	}
+	return active, r.peak, r.total, nil
+}
+
+// runForShard returns the test's current run ID, creating its counter row if needed. The row
+// stays share locked until tx ends, so archive, which locks it exclusively, can't start a new run
+// until the caller has committed to this one, even if the caller's shard row is new.
+func (s *sqlStore) runForShard(ctx context.Context, tx *sql.Tx, testName string, now time.Time) (int, error) {
+	var runID int
+	err := s.queryRow(ctx, tx, s.d.shareCounter, testName).Scan(&runID)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err = s.exec(ctx, tx, s.d.insertCounter, testName, now); err != nil {
+			return 0, fmt.Errorf("inserting: %w", err)
+		}
+		err = s.queryRow(ctx, tx, s.d.shareCounter, testName).Scan(&runID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("querying current run: %w", err)
+	}
+	return runID, nil
+}
+
+// addShards folds the test's shard counters into r, which must be its current run.
+func (s *sqlStore) addShards(ctx context.Context, q querier, r *runSummary) error {
+	var peak, total int
+	err := s.queryRow(ctx, q, `
+	SELECT COALESCE(max(con_peak), 0), COALESCE(sum(con_total), 0)
+		FROM concurrency_shard
+		WHERE test_name = $1
+	`, r.testName).Scan(&peak, &total)
+	if err != nil {
+		return fmt.Errorf("querying shards: %w", err)
+	}
+	r.total += total
+	if peak > r.peak {
+		r.peak = peak
+	}
+	return nil
+}
+
+// dec releases the invocation's lease and logs its end. An unsharded invocation also recomputes
+// the counter row's active count, which a sharded one leaves alone so it never takes that lock.
+func (s *sqlStore) dec(ctx context.Context, inv *invocation, outcome string) error {
+	now := time.Now().UTC()
+	testName := inv.testName
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("decrementing: beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the counter row before touching leases, matching the order in inc().
+	if inv.shard == 0 {
+		if err = s.lockCounter(ctx, tx, testName); err != nil {
+			return fmt.Errorf("decrementing: %w", err)
+		}
+	}
+	_, err = s.exec(ctx, tx, `
+	DELETE FROM concurrency_lease WHERE lease_id = $1
+	`, inv.id)
+	if err != nil {
+		return fmt.Errorf("decrementing: releasing lease: %w", err)
+	}
+	if err = s.logEnd(ctx, tx, inv, outcome, now); err != nil {
+		return fmt.Errorf("decrementing: %w", err)
+	}
+	if inv.shard == 0 {
+		if err = s.reapLeases(ctx, tx, testName, now); err != nil {
+			return fmt.Errorf("decrementing: %w", err)
+		}
+		if _, _, err = s.updateActive(ctx, tx, testName, now); err != nil {
+			return fmt.Errorf("decrementing: %w", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("decrementing: committing: %w", err)
+	}
+	return nil
+}
+
+// lockCounter takes the test's counter row lock for the rest of tx. A no-op update is used rather
+// than SELECT ... FOR UPDATE, which not every dialect supports.
+func (s *sqlStore) lockCounter(ctx context.Context, tx *sql.Tx, testName string) error {
+	_, err := s.exec(ctx, tx, `
+	UPDATE concurrency SET con_active = con_active WHERE test_name = $1
+	`, testName)
+	if err != nil {
+		return fmt.Errorf("locking counter: %w", err)
+	}
+	return nil
+}
+
+// reapLeases deletes the test's expired leases.
+func (s *sqlStore) reapLeases(ctx context.Context, tx *sql.Tx, testName string, now time.Time) error {
+	_, err := s.exec(ctx, tx, `
+	DELETE FROM concurrency_lease WHERE test_name = $1 AND expires_at < $2
+	`, testName, now)
+	if err != nil {
+		return fmt.Errorf("reaping leases: %w", err)
+	}
+	return nil
+}
+
+// updateActive sets the test's active count from its unexpired leases and raises the peak if
+// needed. The caller must hold the counter row's lock.
+func (s *sqlStore) updateActive(ctx context.Context, tx *sql.Tx, testName string, now time.Time) (active, peak int, err error) {
+	err = s.queryRow(ctx, tx, `
+	SELECT count(*) FROM concurrency_lease WHERE test_name = $1 AND expires_at >= $2
+	`, testName, now).Scan(&active)
+	if err != nil {
+		return 0, 0, fmt.Errorf("counting leases: %w", err)
+	}
+	_, err = s.exec(ctx, tx, `
+	UPDATE concurrency SET
+		con_active = $2,
+		con_peak = CASE WHEN con_peak < $2 THEN $2 ELSE con_peak END
+	WHERE test_name = $1
+	`, testName, active)
+	if err != nil {
+		return 0, 0, fmt.Errorf("updating active: %w", err)
+	}
+	err = s.queryRow(ctx, tx, `
+	SELECT con_peak FROM concurrency WHERE test_name = $1
+	`, testName).Scan(&peak)
+	if err != nil {
+		return 0, 0, fmt.Errorf("updating active: %w", err)
+	}
+	return
+}
+
+// logStart appends the invocation to the log.
+func (s *sqlStore) logStart(ctx context.Context, tx *sql.Tx, inv *invocation, now time.Time) error {
+	_, err := s.exec(ctx, tx, `
+	INSERT INTO concurrency_invocation
+		(activation_id, test_name, run_id, wait_ms, started_at, expires_at, instance_id, instance_seq)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, inv.id, inv.testName, inv.runID, inv.wait.Milliseconds(), now, inv.expires.UTC(), inv.instance, inv.seq)
+	if err != nil {
+		return fmt.Errorf("logging invocation start: %w", err)
+	}
+	return nil
+}
+
+// logEnd appends the end time and outcome of the invocation to the log. The start's row is left
+// as it was, so the log is only ever appended to.
+func (s *sqlStore) logEnd(ctx context.Context, tx *sql.Tx, inv *invocation, outcome string, now time.Time) error {
+	_, err := s.exec(ctx, tx, `
+	INSERT INTO concurrency_invocation_end (activation_id, test_name, ended_at, outcome)
+		VALUES ($1, $2, $3, $4)
+	`, inv.id, inv.testName, now, outcome)
+	if err != nil {
+		return fmt.Errorf("logging invocation end: %w", err)
+	}
+	return nil
+}
+
+// archive saves the current run's counters, including its shards', to concurrency_run and starts a
+// new run. The run's invocations are kept, so it can still be listed, reported on and compared.
+func (s *sqlStore) archive(ctx context.Context, testName string) error {
+	now := time.Now().UTC()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("archiving: beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+	// This waits out sharded invocations, which hold a shared lock on the row once they've read
+	// the run ID.
+	if err = s.lockCounter(ctx, tx, testName); err != nil {
+		return fmt.Errorf("archiving: %w", err)
+	}
+	r := runSummary{testName: testName}
+	err = s.queryRow(ctx, tx, `
+	SELECT run_id, run_started_at, con_peak, con_total FROM concurrency WHERE test_name = $1
+	`, testName).Scan(&r.runID, &r.started, &r.peak, &r.total)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("archiving: %w", err)
+	}
+	if err = s.addShards(ctx, tx, &r); err != nil {
+		return fmt.Errorf("archiving: %w", err)
+	}
+	_, err = s.exec(ctx, tx, `
+	INSERT INTO concurrency_run (test_name, run_id, started_at, archived_at, con_peak, con_total)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, testName, r.runID, r.started, now, r.peak, r.total)
+	if err != nil {
+		return fmt.Errorf("archiving: %w", err)
+	}
+	_, err = s.exec(ctx, tx, `
+	UPDATE concurrency SET
+		run_id = run_id + 1,
+		run_started_at = $2,
+		con_active = 0,
+		con_peak = 0,
+		con_total = 0
+	WHERE test_name = $1
+	`, testName, now)
+	if err != nil {
+		return fmt.Errorf("archiving: starting new run: %w", err)
+	}
+	_, err = s.exec(ctx, tx, `
+	UPDATE concurrency_shard SET con_peak = 0, con_total = 0 WHERE test_name = $1
+	`, testName)
+	if err != nil {
+		return fmt.Errorf("archiving: resetting shards: %w", err)
+	}
+	if _, err = s.exec(ctx, tx, `DELETE FROM concurrency_lease WHERE test_name = $1`, testName); err != nil {
+		return fmt.Errorf("archiving: releasing leases: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("archiving: committing: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) currentRun(ctx context.Context, testName string) (int, error) {
+	var runID int
+	err := s.queryRow(ctx, s.db, `SELECT run_id FROM concurrency WHERE test_name = $1`, testName).Scan(&runID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errTestNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("querying current run: %w", err)
+	}
+	return runID, nil
+}
+
+// run returns the counters of a current or archived run. Active is counted from unexpired leases
+// rather than read from the counter row, which may be stale if the last invocation was killed.
+func (s *sqlStore) run(ctx context.Context, testName string, runID int) (runSummary, error) {
+	r := runSummary{testName: testName, runID: runID}
+	err := s.queryRow(ctx, s.db, `
+	SELECT run_started_at, con_peak, con_total FROM concurrency WHERE test_name = $1 AND run_id = $2
+	`, testName, runID).Scan(&r.started, &r.peak, &r.total)
+	if err == nil {
+		if err = s.addShards(ctx, s.db, &r); err != nil {
+			return runSummary{}, err
+		}
+		err = s.queryRow(ctx, s.db, `
+		SELECT count(*) FROM concurrency_lease WHERE test_name = $1 AND expires_at >= $2
+		`, testName, time.Now().UTC()).Scan(&r.active)
+		if err != nil {
+			return runSummary{}, fmt.Errorf("counting leases: %w", err)
+		}
+		return r, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return runSummary{}, fmt.Errorf("querying run: %w", err)
+	}
+	err = s.queryRow(ctx, s.db, `
+	SELECT started_at, archived_at, con_peak, con_total FROM concurrency_run
+		WHERE test_name = $1 AND run_id = $2
+	`, testName, runID).Scan(&r.started, &r.archivedAt, &r.peak, &r.total)
+	if errors.Is(err, sql.ErrNoRows) {
+		return runSummary{}, fmt.Errorf("%w: %d", errRunNotFound, runID)
+	}
+	if err != nil {
+		return runSummary{}, fmt.Errorf("querying archived run: %w", err)
+	}
+	return r, nil
+}
+
+func (s *sqlStore) runs(ctx context.Context, testName string) ([]runSummary, error) {
+	// The current and archived runs are read separately as UNION loses column types in some
+	// dialects.
+	current, err := s.scanRuns(ctx, `
+	SELECT test_name, run_id, run_started_at, NULL, con_peak, con_total
+		FROM concurrency
+		WHERE $1 = '' OR test_name = $1
+	`, testName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range current {
+		if err = s.addShards(ctx, s.db, &current[i]); err != nil {
+			return nil, err
+		}
+	}
+	archived, err := s.scanRuns(ctx, `
+	SELECT test_name, run_id, started_at, archived_at, con_peak, con_total
+		FROM concurrency_run
+		WHERE $1 = '' OR test_name = $1
+	`, testName)
+	if err != nil {
+		return nil, err
+	}
+	out := append(current, archived...)
+	sortRuns(out)
+	return out, nil
+}
+
+func (s *sqlStore) scanRuns(ctx context.Context, query string, args ...interface{}) ([]runSummary, error) {
+	rows, err := s.query(ctx, s.db, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying runs: %w", err)
+	}
+	defer rows.Close()
+	var out []runSummary
+	for rows.Next() {
+		var r runSummary
+		var archivedAt sql.NullTime
+		if err := rows.Scan(&r.testName, &r.runID, &r.started, &archivedAt, &r.peak, &r.total); err != nil {
+			return nil, fmt.Errorf("scanning run: %w", err)
+		}
+		r.archivedAt = archivedAt.Time
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("querying runs: %w", err)
+	}
+	return out, nil
+}
+
+// invocations joins each logged start with its end, if it has logged one.
+func (s *sqlStore) invocations(ctx context.Context, testName string, runID int) ([]invocationRecord, error) {
+	rows, err := s.query(ctx, s.db, `
+	SELECT i.started_at, i.expires_at, e.ended_at, e.outcome
+		FROM concurrency_invocation i
+		LEFT JOIN concurrency_invocation_end e ON e.activation_id = i.activation_id
+		WHERE i.test_name = $1 AND i.run_id = $2
+		ORDER BY i.started_at
+	`, testName, runID)
+	if err != nil {
+		return nil, fmt.Errorf("querying invocations: %w", err)
+	}
+	defer rows.Close()
+	var out []invocationRecord
+	for rows.Next() {
+		var rec invocationRecord
+		var ended sql.NullTime
+		var outcome sql.NullString
+		if err := rows.Scan(&rec.started, &rec.expires, &ended, &outcome); err != nil {
+			return nil, fmt.Errorf("scanning invocation: %w", err)
+		}
+		rec.ended, rec.outcome = ended.Time, outcome.String
+		out = append(out, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("querying invocations: %w", err)
+	}
+	return out, nil
+}
+
+func (s *sqlStore) instanceStats(ctx context.Context, testName string, runID int) (instanceStats, error) {
+	var st instanceStats
+	err := s.queryRow(ctx, s.db, `
+	SELECT
+		count(*),
+		count(DISTINCT instance_id),
+		COALESCE(SUM(CASE WHEN instance_seq = 1 THEN 1 ELSE 0 END), 0)
+	FROM concurrency_invocation
+	WHERE test_name = $1 AND run_id = $2
+	`, testName, runID).Scan(&st.invocations, &st.instances, &st.coldStarts)
+	if err != nil {
+		return instanceStats{}, fmt.Errorf("querying instance stats: %w", err)
+	}
+	return st, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/xo/dburl"
+)
+
+func TestBind(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		questionMarks bool
+		query         string
+		args          []interface{}
+		wantQuery     string
+		wantArgs      []interface{}
+	}{
+		{
+			name:      "postgres leaves placeholders alone",
+			query:     `SELECT $1, $2, $1`,
+			args:      []interface{}{"a", "b"},
+			wantQuery: `SELECT $1, $2, $1`,
+			wantArgs:  []interface{}{"a", "b"},
+		},
+		{
+			name:          "in order",
+			questionMarks: true,
+			query:         `INSERT INTO t (a, b) VALUES ($1, $2)`,
+			args:          []interface{}{"a", 2},
+			wantQuery:     `INSERT INTO t (a, b) VALUES (?, ?)`,
+			wantArgs:      []interface{}{"a", 2},
+		},
+		{
+			name:          "repeated and out of order",
+			questionMarks: true,
+			query:         `UPDATE t SET a = $2 WHERE b = $1 AND c > $2 AND d = $1`,
+			args:          []interface{}{"x", 7},
+			wantQuery:     `UPDATE t SET a = ? WHERE b = ? AND c > ? AND d = ?`,
+			wantArgs:      []interface{}{7, "x", 7, "x"},
+		},
+		{
+			name:          "two digits",
+			questionMarks: true,
+			query:         `SELECT $10, $1`,
+			args:          []interface{}{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+			wantQuery:     `SELECT ?, ?`,
+			wantArgs:      []interface{}{10, 1},
+		},
+		{
+			name:          "no placeholders",
+			questionMarks: true,
+			query:         `SELECT 1`,
+			wantQuery:     `SELECT 1`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &sqlStore{d: &dialect{questionMarks: tc.questionMarks}}
+			query, args := s.bind(tc.query, tc.args)
+			if query != tc.wantQuery {
+				t.Errorf("query = %q, want %q", query, tc.wantQuery)
+			}
+			if !reflect.DeepEqual(args, tc.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tc.wantArgs)
+			}
+		})
+	}
+}
+
+// TestArchiveShardedRace archives a test while sharded invocations, many on shards which don't
+// exist yet, are starting, and checks each run's total matches the invocations logged in it.
+func TestArchiveShardedRace(t *testing.T) {
+	const invocations, archives = 64, 4
+	ctx := context.Background()
+	for name, open := range sqlTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			st := open(t)
+			if err := st.ensureSchema(ctx); err != nil {
+				t.Fatal(err)
+			}
+			var wg sync.WaitGroup
+			errs := make(chan error, invocations+archives)
+			for i := 0; i < invocations; i++ {
+				wg.Add(1)
+				go func(shard int) {
+					defer wg.Done()
+					_, _, _, err := st.inc(ctx, testInvocation("race", shard))
+					errs <- err
+				}(i + 1)
+			}
+			for i := 0; i < archives; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs <- st.archive(ctx, "race")
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			runs, err := st.runs(ctx, "race")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sum int
+			for _, r := range runs {
+				logged, err := st.invocations(ctx, "race", r.runID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r.total != len(logged) {
+					t.Errorf("run %d: total = %d, but %d invocations were logged in it", r.runID, r.total, len(logged))
+				}
+				sum += r.total
+			}
+			if sum != invocations {
+				t.Errorf("runs total %d, want %d", sum, invocations)
+			}
+		})
+	}
+}
+
+// BenchmarkInc compares an unsharded counter with a sharded one under parallel invocations. The
+// contention sharding removes only shows against a real Postgres or MySQL server: without one it
+// falls back to a temp SQLite database, which serializes every writer regardless of shards, so
+// both cases measure the same single lock. Run it from tools/ with DATABASE_URL set, e.g.
+//
+//	DATABASE_URL=postgres://localhost/load?sslmode=disable \
+//		go run ./owlocal -project ../project.yml -test -test-flags "-run ^$ -bench Inc" load/concurrency
+//	DATABASE_URL=mysql://root@localhost/load \
+//		go run ./owlocal -project ../project.yml -test -test-flags "-run ^$ -bench Inc" load/concurrency
+func BenchmarkInc(b *testing.B) {
+	ctx := context.Background()
+	var st *sqlStore
+	if u, err := dburl.Parse(os.Getenv("DATABASE_URL")); err == nil && (u.Driver == "postgres" || u.Driver == "mysql") {
+		s, err := openStore(serverTestURL(b))
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer s.close()
+		st = s.(*sqlStore)
+	} else {
+		st = sqliteTestStore(b)
+	}
+	b.Logf("dialect %s", st.d.name)
+	st.setPool(poolConfig{maxOpenConns: 64, maxIdleConns: 64})
+	if err := st.ensureSchema(ctx); err != nil {
+		b.Fatal(err)
+	}
+	for _, shards := range []int{0, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			testName := "bench_" + randomID(4)
+			b.SetParallelism(8)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					inv := testInvocation(testName, pickShard(shards))
+					if _, _, _, err := st.inc(ctx, inv); err != nil {
+						b.Error(err)
+						return
+					}
+					if err := st.dec(ctx, inv, "ok"); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// TestInvocationLog checks each invocation's end is appended to the log as a row of its own, and
+// that starts and ends are read back together.
+func TestInvocationLog(t *testing.T) {
+	ctx := context.Background()
+	for name, open := range sqlTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			st := open(t)
+			if err := st.ensureSchema(ctx); err != nil {
+				t.Fatal(err)
+			}
+			ended, running := testInvocation("log", 0), testInvocation("log", 0)
+			for _, inv := range []*invocation{ended, running} {
+				if _, _, _, err := st.inc(ctx, inv); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := st.dec(ctx, ended, outcomeError); err != nil {
+				t.Fatal(err)
+			}
+
+			recs, err := st.invocations(ctx, "log", ended.runID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(recs) != 2 {
+				t.Fatalf("got %d invocations, want 2", len(recs))
+			}
+			var outcomes []string
+			for _, rec := range recs {
+				if rec.ended.IsZero() != (rec.outcome == "") || (!rec.ended.IsZero() && rec.ended.Before(rec.started)) {
+					t.Errorf("invocation started %s ended %s with outcome %q", rec.started, rec.ended, rec.outcome)
+				}
+				outcomes = append(outcomes, rec.outcome)
+			}
+			if outcomes[0]+outcomes[1] != outcomeError {
+				t.Errorf("outcomes = %q, want one %q and one still running", outcomes, outcomeError)
+			}
+
+			var id, outcome string
+			err = st.db.QueryRowContext(ctx, `SELECT activation_id, outcome FROM concurrency_invocation_end`).Scan(&id, &outcome)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != ended.id || outcome != outcomeError {
+				t.Errorf("logged end of %s with outcome %q, want %s with %q", id, outcome, ended.id, outcomeError)
+			}
+		})
+	}
+}
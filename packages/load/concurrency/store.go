@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xo/dburl"
+)
+
+// store persists each test's counters, leases, invocation log and archived runs. The backend is
+// chosen by the DATABASE_URL scheme: redis://, rediss:// and unix:// use redisStore, anything else dburl
+// understands is looked up in openers. SQLite URLs (sqlite:, file:) only work in builds with cgo.
+type store interface {
+	// migrate applies any pending schema migrations, returning the version before and after.
+	migrate(ctx context.Context) (from, to int, err error)
+	// ensureSchema migrates the first time it's called on this instance.
+	ensureSchema(ctx context.Context) error
+
+	// inc registers a lease for the invocation, logs its start and bumps the test's counters. It
+	// sets inv.runID.
+	inc(ctx context.Context, inv *invocation) (active, peak, total int, err error)
+	// dec releases the invocation's lease, logs its end and recomputes the test's active count.
+	dec(ctx context.Context, inv *invocation, outcome string) error
+	// archive saves the test's current run and starts a new one.
+	archive(ctx context.Context, testName string) error
+
+	// currentRun returns the test's current run ID, or errTestNotFound.
+	currentRun(ctx context.Context, testName string) (int, error)
+	// run returns the counters of a current or archived run, or errRunNotFound.
+	run(ctx context.Context, testName string, runID int) (runSummary, error)
+	// runs lists every run, or just those of testName if it's not empty.
+	runs(ctx context.Context, testName string) ([]runSummary, error)
+	// invocations returns the run's logged invocations, ordered by start.
+	invocations(ctx context.Context, testName string, runID int) ([]invocationRecord, error)
+	// instanceStats summarizes the processes which have served the run.
+	instanceStats(ctx context.Context, testName string, runID int) (instanceStats, error)
+
+	// setPool applies pool limits.
+	setPool(cfg poolConfig)
+	close() error
+}
+
+// openers open a store for each supported dburl driver.
+var openers = map[string]func(u *dburl.URL) (store, error){
+	"postgres": openPostgres,
+	"mysql":    openMySQL,
+	"sqlite3":  openSQLite,
+}
+
+// Pool defaults. Each instance handles one invocation at a time, so it rarely needs more than a
+// couple of connections.
+const (
+	defaultMaxOpenConns    = 2
+	defaultMaxIdleConns    = 2
+	defaultConnMaxLifetime = 5 * time.Minute
+)
+
+var (
+	storeMu sync.Mutex
+	// sharedStore is opened by the first invocation of this instance and reused by warm
+	// invocations.
+	sharedStore store
+	// sharedStoreURL is the DATABASE_URL sharedStore was opened with.
+	sharedStoreURL string
+)
+
+// poolConfig controls the shared connection pool.
+type poolConfig struct {
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+}
+
+// getPoolConfig reads pool limits from the `maxopen`, `maxidle` and `maxlifetime` args, falling
+// back to the DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS and DB_CONN_MAX_LIFETIME env vars.
+func getPoolConfig(args map[string]interface{}) (poolConfig, error) {
+	cfg := poolConfig{
+		maxOpenConns:    defaultMaxOpenConns,
+		maxIdleConns:    defaultMaxIdleConns,
+		connMaxLifetime: defaultConnMaxLifetime,
+	}
+	var err error
+	if cfg.maxOpenConns, err = intSetting(args, "maxopen", "DB_MAX_OPEN_CONNS", cfg.maxOpenConns); err != nil {
+		return poolConfig{}, err
+	}
+	if cfg.maxIdleConns, err = intSetting(args, "maxidle", "DB_MAX_IDLE_CONNS", cfg.maxIdleConns); err != nil {
+		return poolConfig{}, err
+	}
+	lifetime := setting(args, "maxlifetime", "DB_CONN_MAX_LIFETIME")
+	if lifetime != "" {
+		if cfg.connMaxLifetime, err = time.ParseDuration(lifetime); err != nil {
+			return poolConfig{}, fmt.Errorf("parsing maxlifetime: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// setting returns the named arg, or the env var (if any) if the arg isn't set. Numeric args are
+// formatted as they would appear in a query string.
+func setting(args map[string]interface{}, arg, env string) string {
+	switch v := args[arg].(type) {
+	case string:
+		if v != "" {
+			return v
+		}
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	if env == "" {
+		return ""
+	}
+	return os.Getenv(env)
+}
+
+// intSetting is intArg, falling back to the env var (if any) if the arg isn't set.
+func intSetting(args map[string]interface{}, arg, env string, def int) (int, error) {
+	if !argSet(args, arg) && env != "" {
+		args = map[string]interface{}{arg: os.Getenv(env)}
+	}
+	return intArg(args, arg, def)
+}
+
+// getStore returns this instance's shared store, opening it if needed. reused reports whether the
+// store was opened by an earlier invocation. The pool limits are applied on every call so they
+// may be adjusted between invocations.
+func getStore(databaseURL string, cfg poolConfig) (_ store, reused bool, err error) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	if sharedStore != nil && sharedStoreURL != databaseURL {
+		sharedStore.close()
+		sharedStore = nil
+	}
+	if sharedStore == nil {
+		st, err := openStore(databaseURL)
+		if err != nil {
+			return nil, false, err
+		}
+		sharedStore, sharedStoreURL = st, databaseURL
+	} else {
+		reused = true
+	}
+	sharedStore.setPool(cfg)
+	return sharedStore, reused, nil
+}
+
+// redisSchemes are the DATABASE_URL schemes served by redisStore. dburl doesn't know them.
+var redisSchemes = map[string]bool{"redis": true, "rediss": true, "unix": true}
+
+func openStore(databaseURL string) (store, error) {
+	if u, err := url.Parse(databaseURL); err == nil && redisSchemes[u.Scheme] {
+		return openRedis(databaseURL)
+	}
+	u, err := dburl.Parse(databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing DATABASE_URL: %w", err)
+	}
+	open, ok := openers[u.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported DATABASE_URL scheme %q", u.OriginalScheme)
+	}
+	return open(u)
+}
+
+// errorCode returns the driver's code for err, e.g. a Postgres SQLSTATE or a Redis error prefix, or
+// "" if err didn't come from a driver.
+func errorCode(err error) string {
+	for _, d := range dialects {
+		if code := d.errorCode(err); code != "" {
+			return code
+		}
+	}
+	return redisErrorCode(err)
+}
+
+var (
+	errTestNotFound = errors.New("test not found")
+	errRunNotFound  = errors.New("run not found")
+	errInvalidArg   = errors.New("invalid argument")
+)
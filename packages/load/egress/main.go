@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultTimeout bounds each probe if the `timeout` arg isn't given.
+	defaultTimeout = 10 * time.Second
+	// maxCount bounds the `count` arg.
+	maxCount = 100
+	// maxBodyBytes is the most of each response body which is read.
+	maxBodyBytes = 64 << 20
+	// respondMargin is left before the platform's deadline to respond with the probes made so far.
+	respondMargin = 500 * time.Millisecond
+)
+
+// instanceSeq counts invocations handled by this process, so connection reuse can be related to
+// warm starts.
+var instanceSeq int64
+
+var (
+	transportMu sync.Mutex
+	// sharedTransport is kept across warm invocations, so their connections can be reused.
+	sharedTransport *http.Transport
+	// sharedInsecure is whether sharedTransport skips certificate verification.
+	sharedInsecure bool
+)
+
+// probe is the timing of one outbound request. Phases which didn't happen, e.g. DNS for an IP
+// literal or anything but the request on a reused connection, are zero.
+type probe struct {
+	dns, connect, tls, ttfb, total time.Duration
+	reused, wasIdle                bool
+	idleTime                       time.Duration
+	remoteAddr                     string
+	status                         int
+	bytes                          int64
+	err                            error
+}
+
+// Main probes the `url` arg `count` times (default 1). The `mode` selects how far each probe goes:
+// dns resolves the host, tcp also connects, tls also handshakes, and http (the default) GETs the
+// URL, timing each phase with httptrace. HTTP probes share a transport across warm invocations
+// unless `fresh` is set, so connection reuse shows up in the results; `insecure` skips
+// certificate verification. Each probe is bounded by `timeout`, and all of them by the platform's
+// deadline; probes which there's no time left for are skipped. If every probe is skipped, the
+// response is a 504 giving the reason.
+func Main(args map[string]interface{}) map[string]interface{} {
+	seq := atomic.AddInt64(&instanceSeq, 1)
+
+	rawURL, _ := args["url"].(string)
+	if rawURL == "" {
+		return respondErr(args, http.StatusBadRequest, "url is required", "")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return respondErr(args, http.StatusBadRequest, fmt.Sprintf("url must be an absolute http or https URL, got %q", rawURL), "")
+	}
+	mode, _ := args["mode"].(string)
+	if mode == "" {
+		mode = "http"
+	}
+	var run func(ctx context.Context, u *url.URL, insecure, fresh bool) probe
+	switch mode {
+	case "dns":
+		run = probeDNS
+	case "tcp":
+		run = probeTCP
+	case "tls":
+		if u.Scheme != "https" {
+			return respondErr(args, http.StatusBadRequest, "mode=tls needs an https url", "")
+		}
+		run = probeTLS
+	case "http":
+		run = probeHTTP
+	default:
+		return respondErr(args, http.StatusBadRequest, fmt.Sprintf("unknown mode %q", mode), "")
+	}
+	count, err := intArg(args, "count", 1)
+	if err != nil {
+		return respondErr(args, http.StatusBadRequest, err.Error(), "")
+	}
+	if count < 1 || count > maxCount {
+		return respondErr(args, http.StatusBadRequest, fmt.Sprintf("count must be between 1 and %d, got %d", maxCount, count), "")
+	}
+	timeout := defaultTimeout
+	if t, _ := args["timeout"].(string); t != "" {
+		if timeout, err = time.ParseDuration(t); err != nil || timeout <= 0 {
+			return respondErr(args, http.StatusBadRequest, fmt.Sprintf("timeout must be a positive duration, got %q", t), "")
+		}
+	}
+	insecure, fresh := boolArg(args, "insecure"), boolArg(args, "fresh")
+
+	// b is HTML, so failures can be shown in red; everything from outside must be escaped.
+	var b strings.Builder
+	fmt.Fprintf(&b, "📡 %s %s x%d instance_seq=%d\n", mode, html.EscapeString(u.Redacted()), count, seq)
+	invCtx, cancelInv := invocationContext()
+	defer cancelInv()
+	var results []map[string]interface{}
+	failed := 0
+	skipReason := ""
+	for i := 0; i < count; i++ {
+		if invCtx.Err() != nil {
+			skipReason = fmt.Sprintf("out of time, within %s of the platform's deadline", respondMargin)
+			fmt.Fprintf(&b, "<span style=\"color: red;\">skipped %d probes: %s</span>\n", count-i, skipReason)
+			break
+		}
+		ctx, cancel := context.WithTimeout(invCtx, timeout)
+		p := run(ctx, u, insecure, fresh)
+		cancel()
+		r := map[string]interface{}{
+			"dns_ms":     ms(p.dns),
+			"connect_ms": ms(p.connect),
+			"tls_ms":     ms(p.tls),
+			"ttfb_ms":    ms(p.ttfb),
+			"total_ms":   ms(p.total),
+			"reused":     p.reused,
+			"was_idle":   p.wasIdle,
+			"idle_ms":    ms(p.idleTime),
+			"remote":     p.remoteAddr,
+		}
+		if mode == "http" {
+			r["status"] = p.status
+			r["bytes"] = p.bytes
+		}
+		fmt.Fprintf(&b, "#%d dns=%s connect=%s tls=%s ttfb=%s total=%s reused=%t remote=%s",
+			i+1, p.dns, p.connect, p.tls, p.ttfb, p.total, p.reused, html.EscapeString(p.remoteAddr))
+		if mode == "http" {
+			fmt.Fprintf(&b, " status=%d bytes=%d", p.status, p.bytes)
+		}
+		if p.err != nil {
+			failed++
+			r["error"] = p.err.Error()
+			fmt.Fprintf(&b, " <span style=\"color: red;\">error=%s</span>", html.EscapeString(p.err.Error()))
+		}
+		b.WriteString("\n")
+		results = append(results, r)
+	}
+
+	if len(results) == 0 {
+		return respondErr(args, http.StatusGatewayTimeout, fmt.Sprintf("skipped all %d probes: %s", count, skipReason), "")
+	}
+	data := map[string]interface{}{
+		"mode":         mode,
+		"url":          u.Redacted(),
+		"instance_seq": seq,
+		"failed":       failed,
+		"skipped":      count - len(results),
+		"probes":       results,
+	}
+	if skipReason != "" {
+		data["skip_reason"] = skipReason
+	}
+	status := http.StatusOK
+	if failed == len(results) {
+		// Nothing got through, so report it as the upstream failure it is.
+		status = http.StatusBadGateway
+	}
+	return respondHTML(args, status, b.String(), data)
+}
+
+// invocationContext returns a context which ends respondMargin before the platform's deadline.
+// Without a deadline it only ends when cancelled.
+func invocationContext() (context.Context, context.CancelFunc) {
+	if deadline, ok := platformDeadline(); ok {
+		return context.WithDeadline(context.Background(), deadline.Add(-respondMargin))
+	}
+	return context.WithCancel(context.Background())
+}
+
+// probeDNS times resolving the URL's host.
+func probeDNS(ctx context.Context, u *url.URL, insecure, fresh bool) probe {
+	var p probe
+	start := time.Now()
+	addrs, err := resolve(ctx, u.Hostname())
+	p.dns, p.total = time.Since(start), time.Since(start)
+	if err != nil {
+		p.err = fmt.Errorf("resolving: %w", err)
+		return p
+	}
+	p.remoteAddr = strings.Join(addrs, ",")
+	return p
+}
+
+// probeTCP times resolving the URL's host and connecting to its first address.
+func probeTCP(ctx context.Context, u *url.URL, insecure, fresh bool) probe {
+	p, conn := dial(ctx, u)
+	if conn != nil {
+		conn.Close()
+	}
+	return p
+}
+
+// probeTLS is probeTCP followed by a TLS handshake.
+func probeTLS(ctx context.Context, u *url.URL, insecure, fresh bool) probe {
+	start := time.Now()
+	p, conn := dial(ctx, u)
+	if conn == nil {
+		return p
+	}
+	defer conn.Close()
+	tlsStart := time.Now()
+	tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: insecure})
+	err := tc.HandshakeContext(ctx)
+	p.tls = time.Since(tlsStart)
+	p.total = time.Since(start)
+	if err != nil {
+		p.err = fmt.Errorf("tls handshake: %w", err)
+	}
+	return p
+}
+
+// dial resolves the URL's host and connects to the first address, returning nil if either fails.
+func dial(ctx context.Context, u *url.URL) (probe, net.Conn) {
+	var p probe
+	start := time.Now()
+	addrs, err := resolve(ctx, u.Hostname())
+	p.dns = time.Since(start)
+	if err != nil {
+		p.err, p.total = fmt.Errorf("resolving: %w", err), time.Since(start)
+		return p, nil
+	}
+	p.remoteAddr = net.JoinHostPort(addrs[0], port(u))
+	connStart := time.Now()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.remoteAddr)
+	p.connect, p.total = time.Since(connStart), time.Since(start)
+	if err != nil {
+		p.err = fmt.Errorf("connecting: %w", err)
+		return p, nil
+	}
+	return p, conn
+}
+
+// probeHTTP GETs the URL, timing its phases with httptrace.
+func probeHTTP(ctx context.Context, u *url.URL, insecure, fresh bool) probe {
+	var p probe
+	var dnsStart, connStart, tlsStart, wrote time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { p.dns = time.Since(dnsStart) },
+		ConnectStart:         func(string, string) { connStart = time.Now() },
+		ConnectDone:          func(string, string, error) { p.connect = time.Since(connStart) },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { p.tls = time.Since(tlsStart) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { wrote = time.Now() },
+		GotFirstResponseByte: func() { p.ttfb = time.Since(wrote) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			p.reused, p.wasIdle, p.idleTime = info.Reused, info.WasIdle, info.IdleTime
+			p.remoteAddr = info.Conn.RemoteAddr().String()
+		},
+	}
+
+	transport := getTransport(insecure)
+	if fresh {
+		transport = newTransport(insecure)
+		defer transport.CloseIdleConnections()
+	}
+	client := &http.Client{Transport: transport}
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, u.String(), nil)
+	if err != nil {
+		p.err = err
+		return p
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		p.err, p.total = err, time.Since(start)
+		return p
+	}
+	defer resp.Body.Close()
+	p.status = resp.StatusCode
+	// The body must be drained for the connection to be reused.
+	p.bytes, err = io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodyBytes))
+	p.total = time.Since(start)
+	if err != nil {
+		p.err = fmt.Errorf("reading body: %w", err)
+	}
+	return p
+}
+
+// getTransport returns the transport shared by warm invocations, replacing it if the insecure
+// setting changed.
+func getTransport(insecure bool) *http.Transport {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	if sharedTransport != nil && sharedInsecure != insecure {
+		sharedTransport.CloseIdleConnections()
+		sharedTransport = nil
+	}
+	if sharedTransport == nil {
+		sharedTransport, sharedInsecure = newTransport(insecure), insecure
+	}
+	return sharedTransport
+}
+
+func newTransport(insecure bool) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+	return t
+}
+
+func resolve(ctx context.Context, host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, nil
+	}
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+// port returns the URL's port, defaulting by scheme.
+func port(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// probeJSON calls Main for JSON and returns its status and data.
+func probeJSON(t *testing.T, args map[string]interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	args["format"] = "json"
+	out := Main(args)
+	data, ok := out["body"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("body is a %T, want the data", out["body"])
+	}
+	return out["statusCode"].(int), data
+}
+
+// probes returns the data's probes.
+func probes(t *testing.T, data map[string]interface{}) []map[string]interface{} {
+	t.Helper()
+	p, ok := data["probes"].([]map[string]interface{})
+	if !ok {
+		t.Fatalf("probes are a %T: %v", data["probes"], data)
+	}
+	return p
+}
+
+func TestHTTPReuse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	for i, wantReused := range []bool{false, true} {
+		status, data := probeJSON(t, map[string]interface{}{"url": srv.URL})
+		if status != http.StatusOK {
+			t.Fatalf("call %d: status %d: %v", i+1, status, data)
+		}
+		p := probes(t, data)[0]
+		if p["reused"] != wantReused {
+			t.Errorf("call %d: reused = %v, want %t", i+1, p["reused"], wantReused)
+		}
+		if p["status"] != http.StatusOK || p["bytes"] != int64(5) {
+			t.Errorf("call %d: status %v, %v bytes; want 200, 5", i+1, p["status"], p["bytes"])
+		}
+		// The host is an IP literal, so there's never a DNS phase, and a reused connection has no
+		// connect phase either.
+		if p["dns_ms"] != 0.0 || p["tls_ms"] != 0.0 {
+			t.Errorf("call %d: dns_ms %v, tls_ms %v; want 0", i+1, p["dns_ms"], p["tls_ms"])
+		}
+		if connected := p["connect_ms"].(float64) > 0; connected == wantReused {
+			t.Errorf("call %d: connect_ms = %v with reused %t", i+1, p["connect_ms"], wantReused)
+		}
+		if p["ttfb_ms"].(float64) <= 0 || p["total_ms"].(float64) < p["ttfb_ms"].(float64) {
+			t.Errorf("call %d: ttfb_ms %v, total_ms %v", i+1, p["ttfb_ms"], p["total_ms"])
+		}
+	}
+
+	_, data := probeJSON(t, map[string]interface{}{"url": srv.URL, "fresh": "true", "count": 2.0})
+	for i, p := range probes(t, data) {
+		if p["reused"] != false {
+			t.Errorf("fresh probe %d reused a connection", i+1)
+		}
+	}
+}
+
+func TestTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	for _, mode := range []string{"tls", "http"} {
+		status, data := probeJSON(t, map[string]interface{}{"url": srv.URL, "mode": mode, "insecure": true})
+		if status != http.StatusOK {
+			t.Fatalf("%s: status %d: %v", mode, status, data)
+		}
+		p := probes(t, data)[0]
+		if p["connect_ms"].(float64) <= 0 || p["tls_ms"].(float64) <= 0 {
+			t.Errorf("%s: connect_ms %v, tls_ms %v; want both timed", mode, p["connect_ms"], p["tls_ms"])
+		}
+	}
+
+	// httptest's certificate isn't trusted, so every probe fails and it's the upstream's fault.
+	status, data := probeJSON(t, map[string]interface{}{"url": srv.URL, "mode": "tls", "count": "2"})
+	if status != http.StatusBadGateway || data["failed"] != 2 {
+		t.Errorf("untrusted certificate: status %d, %v failed; want 502, 2", status, data["failed"])
+	}
+	if e, _ := probes(t, data)[0]["error"].(string); !strings.Contains(e, "certificate") {
+		t.Errorf("error = %q, want a certificate error", e)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	deadline := time.Now().Add(respondMargin + 200*time.Millisecond)
+	t.Setenv("__OW_DEADLINE", strconv.FormatInt(deadline.UnixMilli(), 10))
+	start := time.Now()
+	status, data := probeJSON(t, map[string]interface{}{"url": srv.URL, "count": 3.0, "fresh": true})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s, past the deadline's margin", elapsed)
+	}
+	if status != http.StatusBadGateway || data["failed"] != 1 || data["skipped"] != 2 {
+		t.Errorf("status %d, %v failed, %v skipped; want 502, 1, 2", status, data["failed"], data["skipped"])
+	}
+}
+
+// TestNoTimeToProbe calls with the deadline already within the margin, so every probe is skipped.
+func TestNoTimeToProbe(t *testing.T) {
+	t.Setenv("__OW_DEADLINE", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	status, data := probeJSON(t, map[string]interface{}{"url": "http://127.0.0.1:1", "count": 3.0})
+	if status != http.StatusGatewayTimeout || data["status"] != http.StatusGatewayTimeout {
+		t.Errorf("status %d, %v; want 504", status, data["status"])
+	}
+	if e, _ := data["error"].(string); !strings.HasPrefix(e, "skipped all 3 probes: out of time") {
+		t.Errorf("error = %q, want the skip reason", e)
+	}
+}
+
+func TestBadArgs(t *testing.T) {
+	for _, args := range []map[string]interface{}{
+		{},
+		{"url": "ftp://example.com"},
+		{"url": "/relative"},
+		{"url": "http://example.com", "mode": "tls"},
+		{"url": "http://example.com", "mode": "bogus"},
+		{"url": "http://example.com", "count": "0"},
+		{"url": "http://example.com", "count": 1.5},
+		{"url": "http://example.com", "timeout": "-1s"},
+	} {
+		if status, data := probeJSON(t, args); status != http.StatusBadRequest {
+			t.Errorf("%v: status %d, want 400: %v", args, status, data)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultBurn is how long mode=cpu spins if neither `duration` nor `iterations` is given.
+	defaultBurn = time.Second
+	// maxWorkers bounds the `workers` arg.
+	maxWorkers = 256
+	// hashBlockSize is the size of the block each sha256 op hashes.
+	hashBlockSize = 1024
+	// sieveLimit is the bound of each primes op's sieve.
+	sieveLimit = 100000
+)
+
+// workloads are the deterministic ops mode=cpu can repeat. Each takes its worker's running state
+// and returns the next, so the work can't be optimized away and the result can be compared
+// between runs.
+var workloads = map[string]func(state uint64) uint64{
+	"sha256": hashOp,
+	"primes": sieveOp,
+}
+
+// hashOp hashes a block seeded from state.
+func hashOp(state uint64) uint64 {
+	var block [hashBlockSize]byte
+	binary.LittleEndian.PutUint64(block[:], state)
+	sum := sha256.Sum256(block[:])
+	return binary.LittleEndian.Uint64(sum[:])
+}
+
+// sieveOp counts the primes below sieveLimit, folding the count into state.
+func sieveOp(state uint64) uint64 {
+	composite := make([]bool, sieveLimit)
+	count := 0
+	for i := 2; i < sieveLimit; i++ {
+		if composite[i] {
+			continue
+		}
+		count++
+		for j := i * i; j < sieveLimit; j += i {
+			composite[j] = true
+		}
+	}
+	return state*31 + uint64(count)
+}
+
+// burnCPU responds to mode=cpu. It runs `workers` goroutines (default 1) of the `workload`
+// (sha256 or primes, default sha256), either for `duration` or until they've done `iterations`
+// ops between them, and reports how much CPU time the instance actually got for it.
+func burnCPU(args map[string]interface{}) map[string]interface{} {
+	workload, _ := args["workload"].(string)
+	if workload == "" {
+		workload = "sha256"
+	}
+	op, ok := workloads[workload]
+	if !ok {
+		return respondErr(args, http.StatusBadRequest, fmt.Sprintf("unknown workload %q", workload), "")
+	}
+	workers, err := intArg(args, "workers", 1)
+	if err != nil {
+		return respondErr(args, http.StatusBadRequest, err.Error(), "")
+	}
+	if workers < 1 || workers > maxWorkers {
+		return respondErr(args, http.StatusBadRequest,
+			fmt.Sprintf("workers must be between 1 and %d, got %d", maxWorkers, workers), "")
+	}
+	iterations, err := intArg(args, "iterations", 0)
+	if err != nil {
+		return respondErr(args, http.StatusBadRequest, err.Error(), "")
+	}
+	if iterations < 0 {
+		return respondErr(args, http.StatusBadRequest, fmt.Sprintf("iterations must not be negative, got %d", iterations), "")
+	}
+	duration := defaultBurn
+	if d, _ := args["duration"].(string); d != "" {
+		if duration, err = time.ParseDuration(d); err != nil {
+			return respondErr(args, http.StatusBadRequest, fmt.Sprintf("failed to parse duration parameter: %v", err), "")
+		}
+		if duration <= 0 {
+			return respondErr(args, http.StatusBadRequest, fmt.Sprintf("duration must be positive, got %s", duration), "")
+		}
+	}
+
+	cpuBefore, err := cpuTime()
+	if err != nil {
+		return respondErr(args, http.StatusInternalServerError, fmt.Sprintf("reading cpu time: %v", err), "")
+	}
+	start := time.Now()
+	var ops []int
+	var result uint64
+	if iterations > 0 {
+		ops, result = burnIterations(op, workers, iterations)
+	} else {
+		ops, result = burnFor(op, workers, start.Add(duration))
+	}
+	wall := time.Since(start)
+	cpuAfter, err := cpuTime()
+	if err != nil {
+		return respondErr(args, http.StatusInternalServerError, fmt.Sprintf("reading cpu time: %v", err), "")
+	}
+	cpu := cpuAfter - cpuBefore
+
+	total := 0
+	perWorker := make([]string, len(ops))
+	for i, n := range ops {
+		total += n
+		perWorker[i] = strconv.Itoa(n)
+	}
+	opsPerSec := float64(total) / wall.Seconds()
+	// cores is the CPU time we got per second of wall time, i.e. how many cores' worth of CPU the
+	// instance was given while all the workers wanted one each.
+	cores := cpu.Seconds() / wall.Seconds()
+
+	body := fmt.Sprintf("🔥 burned %d %s ops on %d workers in %s\n"+
+		"cpu=%s cores=%.3f ops_per_sec=%.1f gomaxprocs=%d num_cpu=%d\nworker_ops=%s result=%016x\n",
+		total, workload, workers, wall, cpu, cores, opsPerSec,
+		runtime.GOMAXPROCS(0), runtime.NumCPU(), strings.Join(perWorker, ","), result)
+	return respond(args, http.StatusOK, body, map[string]interface{}{
+		"mode":        "cpu",
+		"workload":    workload,
+		"workers":     workers,
+		"ops":         total,
+		"worker_ops":  ops,
+		"wall_ms":     float64(wall) / float64(time.Millisecond),
+		"cpu_ms":      float64(cpu) / float64(time.Millisecond),
+		"cores":       cores,
+		"ops_per_sec": opsPerSec,
+		"gomaxprocs":  runtime.GOMAXPROCS(0),
+		"num_cpu":     runtime.NumCPU(),
+		"result":      fmt.Sprintf("%016x", result),
+	})
+}
+
+// burnIterations splits iterations ops between the workers, returning how many each did and the
+// combined result.
+func burnIterations(op func(uint64) uint64, workers, iterations int) ([]int, uint64) {
+	ops := make([]int, workers)
+	states := make([]uint64, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		ops[w] = iterations / workers
+		if w < iterations%workers {
+			ops[w]++
+		}
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			state := uint64(w)
+			for i := 0; i < ops[w]; i++ {
+				state = op(state)
+			}
+			states[w] = state
+		}(w)
+	}
+	wg.Wait()
+	return ops, combine(states)
+}
+
+// burnFor runs ops on every worker until the deadline, returning how many each did and the
+// combined result.
+func burnFor(op func(uint64) uint64, workers int, deadline time.Time) ([]int, uint64) {
+	ops := make([]int, workers)
+	states := make([]uint64, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			state, n := uint64(w), 0
+			for time.Now().Before(deadline) {
+				state = op(state)
+				n++
+			}
+			ops[w], states[w] = n, state
+		}(w)
+	}
+	wg.Wait()
+	return ops, combine(states)
+}
+
+func combine(states []uint64) uint64 {
+	var out uint64
+	for _, s := range states {
+		out ^= s
+	}
+	return out
+}
+
+// cpuTime returns the user and system CPU time used by this process so far.
+func cpuTime() (time.Duration, error) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, err
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano()), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestBurnCPU(t *testing.T) {
+	for workload := range workloads {
+		t.Run(workload, func(t *testing.T) {
+			args := func() map[string]interface{} {
+				return map[string]interface{}{"workload": workload, "workers": "3", "iterations": "10", "format": "json"}
+			}
+			out := burnCPU(args())
+			if out["statusCode"] != http.StatusOK {
+				t.Fatalf("status %v: %v", out["statusCode"], out["body"])
+			}
+			body := out["body"].(map[string]interface{})
+			if body["ops"] != 10 || !reflect.DeepEqual(body["worker_ops"], []int{4, 3, 3}) {
+				t.Errorf("ops = %v, worker_ops = %v; want 10 split 4,3,3", body["ops"], body["worker_ops"])
+			}
+			// The ops are deterministic, so the same iterations give the same result.
+			if again := burnCPU(args())["body"].(map[string]interface{}); again["result"] != body["result"] {
+				t.Errorf("result = %v, then %v", body["result"], again["result"])
+			}
+		})
+	}
+}
+
+func TestBurnCPUDuration(t *testing.T) {
+	out := burnCPU(map[string]interface{}{"duration": "50ms", "format": "json"})
+	if out["statusCode"] != http.StatusOK {
+		t.Fatalf("status %v: %v", out["statusCode"], out["body"])
+	}
+	body := out["body"].(map[string]interface{})
+	if body["wall_ms"].(float64) < 50 || body["ops"].(int) < 1 || body["workload"] != "sha256" || body["workers"] != 1 {
+		t.Errorf("body = %v, want at least 50ms of sha256 on one worker", body)
+	}
+}
+
+func TestBurnCPURejectsBadArgs(t *testing.T) {
+	for _, args := range []map[string]interface{}{
+		{"workload": "md5"},
+		{"workers": "0"},
+		{"workers": "257"},
+		{"workers": "x"},
+		{"iterations": "-1"},
+		{"duration": "0s"},
+		{"duration": "-1s"},
+		{"duration": "soon"},
+	} {
+		if out := burnCPU(args); out["statusCode"] != http.StatusBadRequest {
+			t.Errorf("%v: status = %v, want 400", args, out["statusCode"])
+		}
+	}
+}
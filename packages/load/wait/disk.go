@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultDiskMB is how much mode=disk writes if `mb` isn't given.
+	defaultDiskMB = 16
+	// maxDiskMB bounds the `mb` arg of mode=disk.
+	maxDiskMB = 4096
+	// seqBlock is the request size of the sequential phases.
+	seqBlock = 1 << 20
+	// defaultRandBlock is the request size of the random phases if `block` isn't given.
+	defaultRandBlock = 4096
+)
+
+// diskPhase is the result of one pass over the file.
+type diskPhase struct {
+	name  string
+	bytes int64
+	ops   int
+	took  time.Duration
+	// fsync is how long the phase's closing fsync took, included in took. Zero for reads.
+	fsync time.Duration
+}
+
+func (p diskPhase) mbPerSec() float64 {
+	return float64(p.bytes) / (1 << 20) / p.took.Seconds()
+}
+
+func (p diskPhase) iops() float64 {
+	return float64(p.ops) / p.took.Seconds()
+}
+
+// diskIO responds to mode=disk. It writes `mb` MiB (default 16) to a file in os.TempDir()
+// sequentially in 1MiB requests, fsyncs and reads it back, then overwrites and reads back every
+// `block` byte block (default 4096) in a random order (reproducible with `seed`). Every read is
+// verified. Reads are likely served from the page cache, so they show what the instance's cache
+// can do rather than the device. The file is removed however the test ends.
+func diskIO(args map[string]interface{}) map[string]interface{} {
+	mb, err := intArg(args, "mb", defaultDiskMB)
+	if err != nil {
+		return respondErr(args, http.StatusBadRequest, err.Error(), "")
+	}
+	if mb < 1 || mb > maxDiskMB {
+		return respondErr(args, http.StatusBadRequest, fmt.Sprintf("mb must be between 1 and %d, got %d", maxDiskMB, mb), "")
+	}
+	block, err := intArg(args, "block", defaultRandBlock)
+	if err != nil {
+		return respondErr(args, http.StatusBadRequest, err.Error(), "")
+	}
+	if block < 512 || block > seqBlock || seqBlock%block != 0 {
+		return respondErr(args, http.StatusBadRequest,
+			fmt.Sprintf("block must be between 512 and %d and divide it, got %d", seqBlock, block), "")
+	}
+	seed, err := int64Arg(args, "seed", time.Now().UnixNano())
+	if err != nil {
+		return respondErr(args, http.StatusBadRequest, err.Error(), "")
+	}
+
+	dir := os.TempDir()
+	freeBefore := freeSpace(dir)
+	phases, err := runDisk(dir, int64(mb)<<20, block, rand.New(rand.NewSource(seed)))
+	if err != nil {
+		return respondErr(args, http.StatusInternalServerError, err.Error(), "")
+	}
+	freeAfter := freeSpace(dir)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "💾 %dMiB in %s, random block=%d seed=%d\n", mb, dir, block, seed)
+	fmt.Fprintf(&b, "free_before=%d free_after=%d\n", freeBefore, freeAfter)
+	phaseData := make([]map[string]interface{}, len(phases))
+	for i, p := range phases {
+		fmt.Fprintf(&b, "%s: %s %.1fMiB/s %.0f iops fsync=%s\n", p.name, p.took, p.mbPerSec(), p.iops(), p.fsync)
+		phaseData[i] = map[string]interface{}{
+			"phase":       p.name,
+			"bytes":       p.bytes,
+			"ops":         p.ops,
+			"ms":          float64(p.took) / float64(time.Millisecond),
+			"fsync_ms":    float64(p.fsync) / float64(time.Millisecond),
+			"mib_per_sec": p.mbPerSec(),
+			"iops":        p.iops(),
+		}
+	}
+	return respond(args, http.StatusOK, b.String(), map[string]interface{}{
+		"mode":        "disk",
+		"dir":         dir,
+		"bytes":       int64(mb) << 20,
+		"block":       block,
+		"seed":        fmt.Sprint(seed),
+		"free_before": freeBefore,
+		"free_after":  freeAfter,
+		"phases":      phaseData,
+		"verified":    true,
+	})
+}
+
+// runDisk runs the phases of mode=disk against a temp file in dir, which it always removes.
+func runDisk(dir string, size int64, block int, r *rand.Rand) (phases []diskPhase, err error) {
+	f, err := os.CreateTemp(dir, "load-wait-disk-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temp file: %w", err)
+	}
+	defer func() {
+		f.Close()
+		if rmErr := os.Remove(f.Name()); rmErr != nil && err == nil {
+			err = fmt.Errorf("removing temp file: %w", rmErr)
+		}
+	}()
+
+	// The random phases visit every block in a different order each. The orders are computed as
+	// they go, as at 4GiB in 512 byte blocks a slice of offsets would take 64MiB per phase.
+	blocks := size / int64(block)
+	writeOrder, readOrder := newPermutation(uint64(blocks), r), newPermutation(uint64(blocks), r)
+	seq := func(i int64) int64 { return i * seqBlock }
+	randWrite := func(i int64) int64 { return int64(writeOrder.at(uint64(i))) * int64(block) }
+	randRead := func(i int64) int64 { return int64(readOrder.at(uint64(i))) * int64(block) }
+
+	for _, ph := range []struct {
+		name   string
+		write  bool
+		gen    uint64
+		block  int
+		ops    int64
+		offset func(i int64) int64
+	}{
+		{"seq_write", true, 1, seqBlock, size / seqBlock, seq},
+		{"seq_read", false, 1, seqBlock, size / seqBlock, seq},
+		{"rand_write", true, 2, block, blocks, randWrite},
+		{"rand_read", false, 2, block, blocks, randRead},
+	} {
+		p, err := diskPass(f, ph.name, ph.write, ph.gen, ph.block, ph.ops, ph.offset)
+		if err != nil {
+			return nil, err
+		}
+		phases = append(phases, p)
+	}
+	return phases, nil
+}
+
+// diskPass writes or reads and verifies a block at offset(i) for each i in [0, ops). Each block is
+// tagged with its generation and offset, so a read which returns stale or misplaced data is
+// caught. A write pass ends with an fsync.
+func diskPass(f *os.File, name string, write bool, gen uint64, block int, ops int64, offset func(i int64) int64) (diskPhase, error) {
+	buf := make([]byte, block)
+	want := make([]byte, block)
+	start := time.Now()
+	for i := int64(0); i < ops; i++ {
+		off := offset(i)
+		fillBlock(want, gen, off)
+		if write {
+			if _, err := f.WriteAt(want, off); err != nil {
+				return diskPhase{}, fmt.Errorf("%s at %d: %w", name, off, err)
+			}
+			continue
+		}
+		if _, err := f.ReadAt(buf, off); err != nil && err != io.EOF {
+			return diskPhase{}, fmt.Errorf("%s at %d: %w", name, off, err)
+		}
+		if string(buf) != string(want) {
+			return diskPhase{}, fmt.Errorf("%s at %d: read back data doesn't match what was written", name, off)
+		}
+	}
+	p := diskPhase{name: name, bytes: int64(block) * ops, ops: int(ops)}
+	if write {
+		syncStart := time.Now()
+		if err := f.Sync(); err != nil {
+			return diskPhase{}, fmt.Errorf("%s: fsync: %w", name, err)
+		}
+		p.fsync = time.Since(syncStart)
+	}
+	p.took = time.Since(start)
+	return p, nil
+}
+
+// permutation is a pseudo-random permutation of [0, n) which computes each element on demand. It's
+// a Feistel network over the smallest even number of bits covering n, applied again to any result
+// out of range until one is in it, which is called cycle walking. As the network permutes its
+// whole domain, the walk from an index in range always comes back into range.
+type permutation struct {
+	n    uint64
+	half uint
+	keys [4]uint64
+}
+
+func newPermutation(n uint64, r *rand.Rand) *permutation {
+	p := &permutation{n: n, half: 1}
+	for uint64(1)<<(2*p.half) < n {
+		p.half++
+	}
+	for i := range p.keys {
+		p.keys[i] = r.Uint64()
+	}
+	return p
+}
+
+// at returns the permutation's i'th element, for i in [0, n).
+func (p *permutation) at(i uint64) uint64 {
+	mask := uint64(1)<<p.half - 1
+	for {
+		left, right := i>>p.half, i&mask
+		for _, k := range p.keys {
+			left, right = right, left^(mix64(right^k)&mask)
+		}
+		if i = left<<p.half | right; i < p.n {
+			return i
+		}
+	}
+}
+
+// mix64 is SplitMix64's finalizer, a cheap hash whose every output bit depends on every input bit.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// fillBlock tags every 8 bytes of b with the generation and its offset in the file.
+func fillBlock(b []byte, gen uint64, off int64) {
+	for i := 0; i+8 <= len(b); i += 8 {
+		binary.LittleEndian.PutUint64(b[i:], gen<<56|uint64(off+int64(i)))
+	}
+}
+
+// freeSpace returns the bytes available to us on dir's filesystem, or -1 if unknown.
+func freeSpace(dir string) int64 {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return -1
+	}
+	return int64(st.Bavail) * int64(st.Bsize)
+}
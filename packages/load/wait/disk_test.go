@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPermutation(t *testing.T) {
+	for _, n := range []uint64{1, 2, 3, 7, 64, 1000, 1 << 16, 1<<16 + 1} {
+		p := newPermutation(n, rand.New(rand.NewSource(int64(n))))
+		seen := make([]bool, n)
+		inPlace := 0
+		for i := uint64(0); i < n; i++ {
+			v := p.at(i)
+			if v >= n || seen[v] {
+				t.Fatalf("n=%d: at(%d) = %d, which is out of range or repeated", n, i, v)
+			}
+			seen[v] = true
+			if v == i {
+				inPlace++
+			}
+		}
+		// A shuffle leaves about one element in place; a broken one leaves most of them.
+		if n >= 64 && inPlace > int(n/8) {
+			t.Errorf("n=%d: %d elements left in place", n, inPlace)
+		}
+	}
+}
+
+func TestRunDisk(t *testing.T) {
+	phases, err := runDisk(t.TempDir(), 4<<20, 512, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name string
+		ops  int
+	}{
+		{"seq_write", 4}, {"seq_read", 4}, {"rand_write", 8192}, {"rand_read", 8192},
+	}
+	if len(phases) != len(want) {
+		t.Fatalf("got %d phases, want %d", len(phases), len(want))
+	}
+	for i, w := range want {
+		if p := phases[i]; p.name != w.name || p.ops != w.ops || p.bytes != 4<<20 {
+			t.Errorf("phase %d = %s, %d ops, %d bytes; want %s, %d ops, 4MiB", i, p.name, p.ops, p.bytes, w.name, w.ops)
+		}
+	}
+}
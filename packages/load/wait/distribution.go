@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// distribution samples a wait.
+type distribution func(r *rand.Rand) time.Duration
+
+// parseWait parses a plain `wait` duration, which must not be negative.
+func parseWait(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("wait must not be negative, got %s", d)
+	}
+	return d, nil
+}
+
+// parseDistribution parses a `wait` spec. Besides a plain, non-negative duration, which is always
+// returned as is, it accepts:
+//
+//	uniform:100ms-2s       uniformly between the bounds
+//	normal:500ms,100ms     normally distributed with the mean and standard deviation
+//	exp:500ms              exponentially distributed with the mean
+//	pareto:100ms,1.5       Pareto distributed with the scale (minimum) and shape
+//	50ms@9|exp:1s@1        a weighted mix of the above, separated by |; weights default to 1
+//
+// Samples are never negative.
+func parseDistribution(spec string) (distribution, error) {
+	if strings.Contains(spec, "|") || strings.Contains(spec, "@") {
+		return parseMix(spec)
+	}
+	kind, params := "", spec
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, params = spec[:i], spec[i+1:]
+	}
+	switch kind {
+	case "":
+		d, err := parseWait(spec)
+		if err != nil {
+			return nil, err
+		}
+		return func(*rand.Rand) time.Duration { return d }, nil
+	case "uniform":
+		bounds := strings.SplitN(params, "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("uniform wants min-max, got %q", params)
+		}
+		lo, hi, err := parseDurations(bounds[0], bounds[1])
+		if err != nil {
+			return nil, err
+		}
+		if hi < lo {
+			return nil, fmt.Errorf("uniform max %s is less than min %s", hi, lo)
+		}
+		// lo can't be negative, as a leading - would be taken as the separator, so the span fits
+		// in an int64, but the span of [0, math.MaxInt64] has no room for the +1.
+		span := int64(hi - lo)
+		return func(r *rand.Rand) time.Duration {
+			if span == math.MaxInt64 {
+				return lo + time.Duration(r.Int63())
+			}
+			return lo + time.Duration(r.Int63n(span+1))
+		}, nil
+	case "normal":
+		p := strings.Split(params, ",")
+		if len(p) != 2 {
+			return nil, fmt.Errorf("normal wants mean,stddev, got %q", params)
+		}
+		mean, stddev, err := parseDurations(p[0], p[1])
+		if err != nil {
+			return nil, err
+		}
+		if mean < 0 {
+			return nil, fmt.Errorf("normal mean must not be negative, got %s", mean)
+		}
+		if stddev <= 0 {
+			return nil, fmt.Errorf("normal stddev must be positive, got %s", stddev)
+		}
+		return func(r *rand.Rand) time.Duration {
+			return nonNegative(float64(mean) + r.NormFloat64()*float64(stddev))
+		}, nil
+	case "exp":
+		mean, err := time.ParseDuration(params)
+		if err != nil {
+			return nil, err
+		}
+		if mean <= 0 {
+			return nil, fmt.Errorf("exp mean must be positive, got %s", mean)
+		}
+		return func(r *rand.Rand) time.Duration {
+			return nonNegative(r.ExpFloat64() * float64(mean))
+		}, nil
+	case "pareto":
+		p := strings.Split(params, ",")
+		if len(p) != 2 {
+			return nil, fmt.Errorf("pareto wants scale,shape, got %q", params)
+		}
+		scale, err := time.ParseDuration(p[0])
+		if err != nil {
+			return nil, err
+		}
+		if scale <= 0 {
+			return nil, fmt.Errorf("pareto scale must be positive, got %s", scale)
+		}
+		shape, err := strconv.ParseFloat(p[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing pareto shape: %w", err)
+		}
+		if !isPositive(shape) {
+			return nil, fmt.Errorf("pareto shape must be positive, got %v", shape)
+		}
+		return func(r *rand.Rand) time.Duration {
+			// 1-Float64 is in (0, 1], so this never divides by zero.
+			return nonNegative(float64(scale) / math.Pow(1-r.Float64(), 1/shape))
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown distribution %q", kind)
+}
+
+// parseMix parses a weighted mix of distributions.
+func parseMix(spec string) (distribution, error) {
+	var dists []distribution
+	var cumulative []float64
+	var total float64
+	for _, entry := range strings.Split(spec, "|") {
+		weight := 1.0
+		if i := strings.LastIndex(entry, "@"); i >= 0 {
+			var err error
+			if weight, err = strconv.ParseFloat(entry[i+1:], 64); err != nil {
+				return nil, fmt.Errorf("parsing weight of %q: %w", entry, err)
+			}
+			entry = entry[:i]
+		}
+		if !isPositive(weight) {
+			return nil, fmt.Errorf("weight of %q must be positive, got %v", entry, weight)
+		}
+		d, err := parseDistribution(entry)
+		if err != nil {
+			return nil, err
+		}
+		total += weight
+		dists = append(dists, d)
+		cumulative = append(cumulative, total)
+	}
+	return func(r *rand.Rand) time.Duration {
+		x := r.Float64() * total
+		for i, c := range cumulative {
+			if x < c {
+				return dists[i](r)
+			}
+		}
+		return dists[len(dists)-1](r)
+	}, nil
+}
+
+func parseDurations(a, b string) (time.Duration, time.Duration, error) {
+	da, err := time.ParseDuration(strings.TrimSpace(a))
+	if err != nil {
+		return 0, 0, err
+	}
+	db, err := time.ParseDuration(strings.TrimSpace(b))
+	if err != nil {
+		return 0, 0, err
+	}
+	return da, db, nil
+}
+
+// isPositive reports whether f is positive and finite.
+func isPositive(f float64) bool {
+	return f > 0 && !math.IsInf(f, 1)
+}
+
+// nonNegative converts nanoseconds to a duration, clamped to [0, math.MaxInt64].
+func nonNegative(ns float64) time.Duration {
+	switch {
+	case ns <= 0 || math.IsNaN(ns):
+		return 0
+	case ns >= math.MaxInt64:
+		return math.MaxInt64
+	}
+	return time.Duration(ns)
+}
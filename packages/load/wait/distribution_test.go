@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDistribution(t *testing.T) {
+	for _, tc := range []struct {
+		spec     string
+		min, max time.Duration
+		// err is a substring of the parse error, if parsing should fail.
+		err string
+	}{
+		{spec: "250ms", min: 250 * time.Millisecond, max: 250 * time.Millisecond},
+		{spec: "0s", min: 0, max: 0},
+		{spec: "-5s", err: "must not be negative"},
+		{spec: "1s@1|-5s@1", err: "must not be negative"},
+		{spec: "uniform:100ms-2s", min: 100 * time.Millisecond, max: 2 * time.Second},
+		{spec: "uniform:1s-1s", min: time.Second, max: time.Second},
+		{spec: "uniform:0s-2562047h47m16.854775807s", min: 0, max: math.MaxInt64},
+		{spec: "uniform:1ns-2562047h47m16.854775807s", min: 1, max: math.MaxInt64},
+		{spec: "uniform:2s-1s", err: "less than min"},
+		{spec: "uniform:-1s-1s", err: "invalid duration"},
+		{spec: "normal:10ms,1s", min: 0, max: math.MaxInt64},
+		{spec: "normal:-10ms,1s", err: "must not be negative"},
+		{spec: "normal:10ms,0s", err: "must be positive"},
+		{spec: "normal:10ms,-1s", err: "must be positive"},
+		{spec: "exp:500ms", min: 0, max: math.MaxInt64},
+		{spec: "exp:-1s", err: "must be positive"},
+		{spec: "exp:0s", err: "must be positive"},
+		{spec: "pareto:100ms,1.5", min: 100 * time.Millisecond, max: math.MaxInt64},
+		{spec: "pareto:100ms,0", err: "must be positive"},
+		{spec: "pareto:100ms,NaN", err: "must be positive"},
+		{spec: "pareto:100ms,Inf", err: "must be positive"},
+		{spec: "pareto:0s,1.5", err: "must be positive"},
+		{spec: "pareto:-100ms,1.5", err: "must be positive"},
+		{spec: "50ms@9|uniform:1s-2s@1", min: 50 * time.Millisecond, max: 2 * time.Second},
+		{spec: "50ms@0|1s", err: "must be positive"},
+		{spec: "50ms@-1|1s", err: "must be positive"},
+		{spec: "50ms@NaN|1s", err: "must be positive"},
+		{spec: "50ms@Inf|1s", err: "must be positive"},
+		{spec: "50ms|exp:-1s@2", err: "must be positive"},
+		{spec: "bogus:1s", err: "unknown distribution"},
+	} {
+		t.Run(tc.spec, func(t *testing.T) {
+			dist, err := parseDistribution(tc.spec)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("err = %v, want one containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := rand.New(rand.NewSource(1))
+			for i := 0; i < 1000; i++ {
+				if d := dist(r); d < tc.min || d > tc.max {
+					t.Fatalf("sample %s is outside [%s, %s]", d, tc.min, tc.max)
+				}
+			}
+		})
+	}
+}
+
+func TestSleepRejectsBadWait(t *testing.T) {
+	for _, wait := range []string{"exp:-1s", "normal:1s,-1s", "pareto:1s,NaN", "1s@NaN|2s"} {
+		out := Main(map[string]interface{}{"wait": wait, "format": "json"})
+		if out["statusCode"] != http.StatusBadRequest {
+			t.Errorf("wait=%s: status %v, want 400", wait, out["statusCode"])
+		}
+		if msg := out["body"].(map[string]interface{})["error"].(string); !strings.HasPrefix(msg, "failed to parse wait parameter: ") {
+			t.Errorf("wait=%s: error = %q", wait, msg)
+		}
+	}
+}
@@ -1,27 +1,134 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Main sleeps for `wait` by default. The `mode` arg selects another workload instead. The
+// `fault` args (see parseFault) make some calls fail instead.
 func Main(args map[string]interface{}) map[string]interface{} {
+	if err := queryArgs(args); err != nil {
+		return respondErr(args, http.StatusBadRequest, err.Error(), "")
+	}
+	// echo and hash take the body as their payload, so their args can only be in the query string.
+	if mode, _ := args["mode"].(string); mode != "echo" && mode != "hash" {
+		if err := bodyArgs(args); err != nil {
+			return respondErr(args, http.StatusBadRequest, err.Error(), "")
+		}
+	}
+	f, err := parseFault(args)
+	if err != nil {
+		return respondErr(args, http.StatusBadRequest, err.Error(), "")
+	}
+	if f != nil && f.due() {
+		return f.inject(args)
+	}
+
+	mode, _ := args["mode"].(string)
+	switch mode {
+	case "", "sleep":
+		return sleep(args)
+	case "cpu":
+		return burnCPU(args)
+	case "memory":
+		return allocMemory(args)
+	case "size":
+		return respondSize(args)
+	case "echo":
+		return echo(args)
+	case "hash":
+		return hashBody(args)
+	case "disk":
+		return diskIO(args)
+	}
+	return respondErr(args, http.StatusBadRequest, fmt.Sprintf("unknown mode %q", mode), "")
+}
+
+// queryArgs adds the query string to args. The action is deployed with `web: raw`, so the platform
+// passes its query string in __ow_query rather than as args. Args which are already set win.
+func queryArgs(args map[string]interface{}) error {
+	raw, _ := args["__ow_query"].(string)
+	if raw == "" {
+		return nil
+	}
+	query, err := url.ParseQuery(raw)
+	if err != nil {
+		return fmt.Errorf("failed to parse query string: %w", err)
+	}
+	for k, v := range query {
+		if _, ok := args[k]; !ok {
+			args[k] = v[len(v)-1]
+		}
+	}
+	return nil
+}
+
+// bodyArgs adds a JSON object or form request body to args, as the platform would if the action
+// weren't raw. Args which are already set win, so the query string overrides the body. Other bodies
+// are left alone.
+func bodyArgs(args map[string]interface{}) error {
+	body, _ := requestBody(args)
+	if len(body) == 0 {
+		return nil
+	}
+	contentType, _, _ := mime.ParseMediaType(requestContentType(args))
+	fields := map[string]interface{}{}
+	switch contentType {
+	case "application/json":
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return fmt.Errorf("failed to parse JSON body: %w", err)
+		}
+	case "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return fmt.Errorf("failed to parse form body: %w", err)
+		}
+		for k, v := range form {
+			fields[k] = v[len(v)-1]
+		}
+	default:
+		return nil
+	}
+	for k, v := range fields {
+		if _, ok := args[k]; !ok && !strings.HasPrefix(k, "__ow_") {
+			args[k] = v
+		}
+	}
+	return nil
+}
+
+// sleep sleeps for `wait`, which may be a distribution (see parseDistribution) sampled with the
+// `seed` arg. Without a seed one is picked and reported, so the sample can be reproduced.
+func sleep(args map[string]interface{}) map[string]interface{} {
 	var wait time.Duration
+	data := map[string]interface{}{}
 	if waitString, _ := args["wait"].(string); waitString != "" {
-		var err error
-		wait, err = time.ParseDuration(waitString)
+		dist, err := parseDistribution(waitString)
 		if err != nil {
-			return map[string]interface{}{
-				"body": fmt.Sprintf("🤮 failed to parse wait parameter: %v\n", err),
-			}
+			return respondErr(args, http.StatusBadRequest, fmt.Sprintf("failed to parse wait parameter: %v", err), "")
 		}
+		seed, err := int64Arg(args, "seed", time.Now().UnixNano())
+		if err != nil {
+			return respondErr(args, http.StatusBadRequest, err.Error(), "")
+		}
+		wait = dist(rand.New(rand.NewSource(seed)))
+		data["wait_spec"] = waitString
+		data["seed"] = strconv.FormatInt(seed, 10)
 	}
 	body := "😵‍💫 no sleep\n"
 	if wait != 0 {
 		time.Sleep(wait)
 		body = fmt.Sprintf("🤩 slept %s\n", wait.String())
 	}
-	return map[string]interface{}{
-		"body": body,
-	}
+	data["wait"] = wait.String()
+	data["wait_ms"] = wait.Milliseconds()
+	return respond(args, http.StatusOK, body, data)
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxMemoryMB bounds the `mb` arg, well beyond any memory tier.
+	maxMemoryMB = 64 * 1024
+	// pageSize is the stride at which allocated memory is touched, so it's actually resident.
+	pageSize = 4096
+)
+
+// memStep is a snapshot of memory use after a step of mode=memory.
+type memStep struct {
+	step      int
+	allocated int // MiB
+	heapAlloc uint64
+	sys       uint64
+	rss       int64 // KiB, or -1 if unknown
+	at        time.Duration
+}
+
+// allocMemory responds to mode=memory. It allocates and touches `mb` MiB in `steps` equal chunks
+// (default 1), spreading the steps evenly over `wait`. Each step is logged as it completes, so if
+// the platform kills the invocation for exceeding its memory limit, the activation's logs show how
+// far it got; only an invocation which survives logs "memory: completed" and gets a response. The
+// memory is released before responding so a warm instance starts from scratch.
+func allocMemory(args map[string]interface{}) map[string]interface{} {
+	mb, err := intArg(args, "mb", 0)
+	if err != nil {
+		return respondErr(args, http.StatusBadRequest, err.Error(), "")
+	}
+	if mb < 1 || mb > maxMemoryMB {
+		return respondErr(args, http.StatusBadRequest, fmt.Sprintf("mb must be between 1 and %d, got %d", maxMemoryMB, mb), "")
+	}
+	steps, err := intArg(args, "steps", 1)
+	if err != nil {
+		return respondErr(args, http.StatusBadRequest, err.Error(), "")
+	}
+	if steps < 1 || steps > mb {
+		return respondErr(args, http.StatusBadRequest, fmt.Sprintf("steps must be between 1 and mb (%d), got %d", mb, steps), "")
+	}
+	var wait time.Duration
+	if waitString, _ := args["wait"].(string); waitString != "" {
+		if wait, err = parseWait(waitString); err != nil {
+			return respondErr(args, http.StatusBadRequest, fmt.Sprintf("failed to parse wait parameter: %v", err), "")
+		}
+	}
+
+	before := snapshot(0, 0, 0)
+	start := time.Now()
+	chunks := make([][]byte, 0, steps)
+	var history []memStep
+	allocated := 0
+	for step := 1; step <= steps; step++ {
+		size := mb / steps
+		if step <= mb%steps {
+			size++
+		}
+		chunk := make([]byte, size<<20)
+		for i := 0; i < len(chunk); i += pageSize {
+			chunk[i] = 1
+		}
+		chunks = append(chunks, chunk)
+		allocated += size
+
+		s := snapshot(step, allocated, time.Since(start))
+		history = append(history, s)
+		fmt.Printf("memory: step=%d/%d allocated=%dMiB heap_alloc=%d sys=%d rss=%dKiB\n",
+			step, steps, allocated, s.heapAlloc, s.sys, s.rss)
+		if step < steps && wait > 0 {
+			time.Sleep(wait / time.Duration(steps))
+		}
+	}
+	peak := history[len(history)-1]
+	hwm := procStatusKB("VmHWM")
+	fmt.Printf("memory: completed allocated=%dMiB in %s\n", allocated, time.Since(start))
+
+	// chunks is dead after this, so FreeOSMemory can hand it back.
+	runtime.KeepAlive(chunks)
+	debug.FreeOSMemory()
+	after := snapshot(steps, 0, time.Since(start))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "🐘 allocated %dMiB in %d steps over %s\n", allocated, steps, time.Since(start))
+	fmt.Fprintf(&b, "rss_before=%dKiB rss_peak=%dKiB rss_hwm=%dKiB rss_after=%dKiB\n", before.rss, peak.rss, hwm, after.rss)
+	fmt.Fprintf(&b, "heap_alloc=%d sys=%d num_gc=%d\n", peak.heapAlloc, peak.sys, numGC())
+	stepData := make([]map[string]interface{}, len(history))
+	for i, s := range history {
+		fmt.Fprintf(&b, "step=%d allocated=%dMiB rss=%dKiB at=%s\n", s.step, s.allocated, s.rss, s.at)
+		stepData[i] = map[string]interface{}{
+			"step":         s.step,
+			"allocated_mb": s.allocated,
+			"heap_alloc":   s.heapAlloc,
+			"sys":          s.sys,
+			"rss_kb":       s.rss,
+			"at_ms":        float64(s.at) / float64(time.Millisecond),
+		}
+	}
+	return respond(args, http.StatusOK, b.String(), map[string]interface{}{
+		"mode":          "memory",
+		"completed":     true,
+		"allocated_mb":  allocated,
+		"history":       stepData,
+		"rss_before_kb": before.rss,
+		"rss_peak_kb":   peak.rss,
+		"rss_hwm_kb":    hwm,
+		"rss_after_kb":  after.rss,
+		"heap_alloc":    peak.heapAlloc,
+		"sys":           peak.sys,
+		"num_gc":        numGC(),
+	})
+}
+
+func snapshot(step, allocated int, at time.Duration) memStep {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return memStep{
+		step:      step,
+		allocated: allocated,
+		heapAlloc: ms.HeapAlloc,
+		sys:       ms.Sys,
+		rss:       procStatusKB("VmRSS"),
+		at:        at,
+	}
+}
+
+func numGC() uint32 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.NumGC
+}
+
+// procStatusKB returns a "<field>: <n> kB" line of /proc/self/status, or -1 if it can't be read,
+// e.g. off Linux.
+func procStatusKB(field string) int64 {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.HasPrefix(line, field+":") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, field+":"))
+		if len(fields) == 0 {
+			return -1
+		}
+		kb, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return -1
+		}
+		return kb
+	}
+	return -1
+}
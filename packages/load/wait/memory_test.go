@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestAllocMemory(t *testing.T) {
+	out := allocMemory(map[string]interface{}{"mb": "4", "steps": "3", "wait": "20ms", "format": "json"})
+	if out["statusCode"] != http.StatusOK {
+		t.Fatalf("status %v: %v", out["statusCode"], out["body"])
+	}
+	body := out["body"].(map[string]interface{})
+	if body["allocated_mb"] != 4 || body["completed"] != true {
+		t.Errorf("body = %v, want 4MiB allocated", body)
+	}
+	// The remainder goes to the first steps.
+	history := body["history"].([]map[string]interface{})
+	for i, want := range []int{2, 3, 4} {
+		if i >= len(history) || history[i]["allocated_mb"] != want {
+			t.Fatalf("history = %v, want allocations 2, 3, 4", history)
+		}
+	}
+}
+
+func TestAllocMemoryRejectsBadArgs(t *testing.T) {
+	for _, args := range []map[string]interface{}{
+		{},
+		{"mb": "0"},
+		{"mb": strconv.Itoa(maxMemoryMB + 1)},
+		{"mb": "x"},
+		{"mb": "2", "steps": "0"},
+		{"mb": "2", "steps": "3"},
+		{"mb": "2", "wait": "-1s"},
+		{"mb": "2", "wait": "soon"},
+	} {
+		if out := allocMemory(args); out["statusCode"] != http.StatusBadRequest {
+			t.Errorf("%v: status = %v, want 400", args, out["statusCode"])
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxPayloadBytes bounds the `bytes` arg. It's far beyond what the platform will return, so the
+// platform's own limit is what's found.
+const maxPayloadBytes = 64 << 20
+
+// payloadLine is repeated to fill a text or JSON payload.
+const payloadLine = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_\n"
+
+// respondSize responds to mode=size with a body of exactly `bytes` bytes. `content` selects text
+// (the default), json, or binary, which is returned base64 encoded with a binary Content-Type so
+// the platform decodes it.
+func respondSize(args map[string]interface{}) map[string]interface{} {
+	n, err := intArg(args, "bytes", 0)
+	if err != nil {
+		return respondErr(args, http.StatusBadRequest, err.Error(), "")
+	}
+	if n < 0 || n > maxPayloadBytes {
+		return respondErr(args, http.StatusBadRequest, fmt.Sprintf("bytes must be between 0 and %d, got %d", maxPayloadBytes, n), "")
+	}
+
+	var contentType, body string
+	switch content, _ := args["content"].(string); content {
+	case "", "text":
+		contentType, body = "text/plain; charset=utf-8", fill(n)
+	case "json":
+		const wrapper = `{"data":""}`
+		if n < len(wrapper) {
+			return respondErr(args, http.StatusBadRequest, fmt.Sprintf("a json payload needs at least %d bytes", len(wrapper)), "")
+		}
+		// The filler is JSON safe apart from its newlines, so they're swapped for spaces.
+		contentType = "application/json"
+		body = `{"data":"` + strings.ReplaceAll(fill(n-len(wrapper)), "\n", " ") + `"}`
+	case "binary":
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(i)
+		}
+		contentType, body = "application/octet-stream", base64.StdEncoding.EncodeToString(b)
+	default:
+		return respondErr(args, http.StatusBadRequest, fmt.Sprintf("unknown content %q", content), "")
+	}
+	return map[string]interface{}{
+		"statusCode": http.StatusOK,
+		"headers": map[string]interface{}{
+			"Content-Type":    contentType,
+			"X-Payload-Bytes": strconv.Itoa(n),
+		},
+		"body": body,
+	}
+}
+
+// fill returns n bytes of payloadLine.
+func fill(n int) string {
+	s := strings.Repeat(payloadLine, n/len(payloadLine)+1)
+	return s[:n]
+}
+
+// echo responds to mode=echo with the request body, unchanged, and its Content-Type.
+func echo(args map[string]interface{}) map[string]interface{} {
+	raw, ok := args["__ow_body"].(string)
+	if !ok {
+		return noBody(args)
+	}
+	body, _ := requestBody(args)
+	contentType := requestContentType(args)
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	return map[string]interface{}{
+		"statusCode": http.StatusOK,
+		"headers": map[string]interface{}{
+			"Content-Type":     contentType,
+			"X-Received-Bytes": strconv.Itoa(len(body)),
+		},
+		// Binary bodies arrive base64 encoded, which is also how they must be returned.
+		"body": raw,
+	}
+}
+
+// hashBody responds to mode=hash with the size and SHA-256 of the request body.
+func hashBody(args map[string]interface{}) map[string]interface{} {
+	raw, ok := args["__ow_body"].(string)
+	if !ok {
+		return noBody(args)
+	}
+	body, encoded := requestBody(args)
+	sum := sha256.Sum256(body)
+	contentType := requestContentType(args)
+	return respond(args, http.StatusOK,
+		fmt.Sprintf("📦 received %d bytes (%d on the wire, base64=%t) of %q\nsha256=%x\n",
+			len(body), len(raw), encoded, contentType, sum),
+		map[string]interface{}{
+			"mode":           "hash",
+			"received_bytes": len(body),
+			"wire_bytes":     len(raw),
+			"base64":         encoded,
+			"content_type":   contentType,
+			"sha256":         hex.EncodeToString(sum[:]),
+		})
+}
+
+// noBody responds to an echo or hash without a raw body. The platform only passes __ow_body to
+// actions deployed as raw HTTP web actions (web: raw); otherwise it parses the body into args.
+func noBody(args map[string]interface{}) map[string]interface{} {
+	return respondErr(args, http.StatusBadRequest,
+		"no __ow_body: deploy the action with `web: raw` to receive the raw request body", "")
+}
+
+// requestBody returns the raw request body. The platform base64 encodes bodies whose Content-Type
+// isn't textual, so they're decoded, which encoded reports.
+func requestBody(args map[string]interface{}) (body []byte, encoded bool) {
+	raw, _ := args["__ow_body"].(string)
+	if !isTextual(requestContentType(args)) {
+		if b, err := base64.StdEncoding.DecodeString(raw); err == nil {
+			return b, true
+		}
+	}
+	return []byte(raw), false
+}
+
+func requestContentType(args map[string]interface{}) string {
+	headers, _ := args["__ow_headers"].(map[string]interface{})
+	ct, _ := headers["content-type"].(string)
+	return ct
+}
+
+// isTextual reports whether the platform passes bodies of contentType through as text.
+func isTextual(contentType string) bool {
+	ct := strings.ToLower(contentType)
+	return ct == "" ||
+		strings.HasPrefix(ct, "text/") ||
+		strings.Contains(ct, "json") ||
+		strings.Contains(ct, "xml") ||
+		strings.HasPrefix(ct, "application/x-www-form-urlencoded")
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestRespondSize(t *testing.T) {
+	for _, tc := range []struct {
+		args        map[string]interface{}
+		status      int
+		contentType string
+		// bytes is the length of the body once the platform has decoded it.
+		bytes int
+	}{
+		{args: map[string]interface{}{"bytes": "0"}, status: http.StatusOK, contentType: "text/plain; charset=utf-8", bytes: 0},
+		{args: map[string]interface{}{"bytes": "1000"}, status: http.StatusOK, contentType: "text/plain; charset=utf-8", bytes: 1000},
+		{args: map[string]interface{}{"bytes": 1000.0, "content": "json"}, status: http.StatusOK, contentType: "application/json", bytes: 1000},
+		{args: map[string]interface{}{"bytes": "300", "content": "binary"}, status: http.StatusOK, contentType: "application/octet-stream", bytes: 300},
+		{args: map[string]interface{}{"bytes": "5", "content": "json"}, status: http.StatusBadRequest},
+		{args: map[string]interface{}{"bytes": "-1"}, status: http.StatusBadRequest},
+		{args: map[string]interface{}{"bytes": strconv.Itoa(maxPayloadBytes + 1)}, status: http.StatusBadRequest},
+		{args: map[string]interface{}{"bytes": "10", "content": "xml"}, status: http.StatusBadRequest},
+	} {
+		out := respondSize(tc.args)
+		if out["statusCode"] != tc.status {
+			t.Errorf("%v: status = %v, want %d", tc.args, out["statusCode"], tc.status)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		headers := out["headers"].(map[string]interface{})
+		if headers["Content-Type"] != tc.contentType || headers["X-Payload-Bytes"] != strconv.Itoa(tc.bytes) {
+			t.Errorf("%v: headers = %v", tc.args, headers)
+		}
+		body := []byte(out["body"].(string))
+		if tc.contentType == "application/octet-stream" {
+			var err error
+			if body, err = base64.StdEncoding.DecodeString(string(body)); err != nil {
+				t.Fatalf("%v: binary body isn't base64: %v", tc.args, err)
+			}
+			for i, b := range body {
+				if b != byte(i) {
+					t.Fatalf("%v: byte %d = %d", tc.args, i, b)
+				}
+			}
+		}
+		if len(body) != tc.bytes {
+			t.Errorf("%v: body is %d bytes, want %d", tc.args, len(body), tc.bytes)
+		}
+		if tc.contentType == "application/json" {
+			var v map[string]string
+			if err := json.Unmarshal(body, &v); err != nil {
+				t.Errorf("%v: body isn't JSON: %v", tc.args, err)
+			}
+		}
+	}
+}
+
+func TestEchoAndHash(t *testing.T) {
+	binary := []byte{0, 1, 2, 0xff, 0xfe}
+	for _, tc := range []struct {
+		name, contentType, wire string
+		body                    []byte
+		encoded                 bool
+	}{
+		{name: "text", contentType: "text/plain", wire: "hello\n", body: []byte("hello\n")},
+		{name: "json", contentType: "application/json", wire: `{"a": [1, 2]}`, body: []byte(`{"a": [1, 2]}`)},
+		{name: "none", wire: "untyped", body: []byte("untyped")},
+		{
+			name: "binary", contentType: "application/octet-stream",
+			wire: base64.StdEncoding.EncodeToString(binary), body: binary, encoded: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args := func(mode string) map[string]interface{} {
+				headers := map[string]interface{}{"accept": "application/json"}
+				if tc.contentType != "" {
+					headers["content-type"] = tc.contentType
+				}
+				return map[string]interface{}{
+					"__ow_query":   "mode=" + mode,
+					"__ow_body":    tc.wire,
+					"__ow_headers": headers,
+				}
+			}
+
+			out := Main(args("echo"))
+			if out["statusCode"] != http.StatusOK {
+				t.Fatalf("echo: %v", out)
+			}
+			// The body goes back as it came, so the platform decodes binary bodies again.
+			if out["body"] != tc.wire {
+				t.Errorf("echo: body = %q, want %q", out["body"], tc.wire)
+			}
+			wantType := tc.contentType
+			if wantType == "" {
+				wantType = "text/plain; charset=utf-8"
+			}
+			headers := out["headers"].(map[string]interface{})
+			if headers["Content-Type"] != wantType || headers["X-Received-Bytes"] != strconv.Itoa(len(tc.body)) {
+				t.Errorf("echo: headers = %v", headers)
+			}
+
+			out = Main(args("hash"))
+			if out["statusCode"] != http.StatusOK {
+				t.Fatalf("hash: %v", out)
+			}
+			data := out["body"].(map[string]interface{})
+			sum := sha256.Sum256(tc.body)
+			if data["sha256"] != hex.EncodeToString(sum[:]) || data["received_bytes"] != len(tc.body) ||
+				data["wire_bytes"] != len(tc.wire) || data["base64"] != tc.encoded || data["content_type"] != tc.contentType {
+				t.Errorf("hash: %v", data)
+			}
+		})
+	}
+}
+
+func TestNoBody(t *testing.T) {
+	for _, mode := range []string{"echo", "hash"} {
+		if out := Main(map[string]interface{}{"mode": mode}); out["statusCode"] != http.StatusBadRequest {
+			t.Errorf("%s without __ow_body: status = %v, want 400", mode, out["statusCode"])
+		}
+	}
+}
+
+func TestQueryArgs(t *testing.T) {
+	args := map[string]interface{}{"__ow_query": "mode=size&bytes=1&bytes=3&content=text", "content": "json"}
+	if err := queryArgs(args); err != nil {
+		t.Fatal(err)
+	}
+	if args["mode"] != "size" || args["bytes"] != "3" || args["content"] != "json" {
+		t.Errorf("args = %v, want the query's last bytes and the arg's content", args)
+	}
+	if out := Main(map[string]interface{}{"__ow_query": "mode=%zz"}); out["statusCode"] != http.StatusBadRequest {
+		t.Errorf("bad query: status = %v, want 400", out["statusCode"])
+	}
+}
+
+func TestBodyArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name, query, contentType, body string
+		status                         int
+		// size is the X-Payload-Bytes of a mode=size response, or "" for another mode.
+		size string
+	}{
+		{name: "json", contentType: "application/json", body: `{"mode": "size", "bytes": 10}`, status: http.StatusOK, size: "10"},
+		{name: "form", contentType: "application/x-www-form-urlencoded", body: "mode=size&bytes=7", status: http.StatusOK, size: "7"},
+		{name: "json with charset", contentType: "application/json; charset=utf-8", body: `{"mode": "size", "bytes": 3}`, status: http.StatusOK, size: "3"},
+		{name: "query wins", query: "bytes=5", contentType: "application/json", body: `{"mode": "size", "bytes": 10}`, status: http.StatusOK, size: "5"},
+		{name: "sleep", contentType: "application/json", body: `{"wait": "1ms"}`, status: http.StatusOK},
+		{name: "bad wait", contentType: "application/json", body: `{"wait": "-1s"}`, status: http.StatusBadRequest},
+		{name: "bad json", contentType: "application/json", body: `{"mode":`, status: http.StatusBadRequest},
+		{name: "json array", contentType: "application/json", body: `[1]`, status: http.StatusBadRequest},
+		{name: "other types are ignored", contentType: "text/plain", body: "mode=size", status: http.StatusOK},
+		// echo's body is its payload, not its args.
+		{name: "echo", query: "mode=echo", contentType: "application/json", body: `{"mode": "size"}`, status: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := Main(map[string]interface{}{
+				"__ow_query":   tc.query + "&format=json",
+				"__ow_body":    tc.body,
+				"__ow_headers": map[string]interface{}{"content-type": tc.contentType},
+			})
+			if out["statusCode"] != tc.status {
+				t.Fatalf("status = %v, want %d: %v", out["statusCode"], tc.status, out["body"])
+			}
+			headers := out["headers"].(map[string]interface{})
+			if got, _ := headers["X-Payload-Bytes"].(string); got != tc.size {
+				t.Errorf("X-Payload-Bytes = %q, want %q", got, tc.size)
+			}
+			if tc.query == "mode=echo" && out["body"] != tc.body {
+				t.Errorf("echo: body = %v, want %q", out["body"], tc.body)
+			}
+		})
+	}
+}
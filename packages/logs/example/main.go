@@ -2,9 +2,10 @@ package main
 
 import (
 	"fmt"
+	"net/http"
 )
 
 func Main(args map[string]interface{}) map[string]interface{} {
 	fmt.Print("😀 request received!  Try DigitalOcean App Platform")
-	return map[string]interface{}{"body": "logged"}
+	return respond(args, http.StatusOK, "logged", map[string]interface{}{"message": "logged"})
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"net/http"
 )
 
 const startupMessage = `                                              [38;5;54;48;5;39m [38;5;54;48;5;39m [38;5;54;48;5;39m [38;5;1;48;5;16m                               [0m
@@ -24,11 +25,5 @@ const startupMessage = `                                              [38;5;54;
 func Main(args map[string]interface{}) map[string]interface{} {
 	fmt.Printf("Received request with parameters: %v", args)
 	fmt.Print(startupMessage)
-	return wrapHTML("hello")
-}
-
-func wrapHTML(body string) map[string]interface{} {
-	return map[string]interface{}{
-		"body": "<html><body><pre>" + string(body) + "</pre></body></html>",
-	}
+	return respond(args, http.StatusOK, "hello", map[string]interface{}{"message": "hello"})
 }
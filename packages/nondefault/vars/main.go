@@ -2,20 +2,20 @@ package main
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 )
 
 func Main(args map[string]interface{}) map[string]interface{} {
-	return wrapHTML(fmt.Sprintf(
-		"PROJECT_LEVEL: %q\nPACKAGE_LEVEL: %q\nACTION_LEVEL: %q\n",
-		os.Getenv("PROJECT_LEVEL"),
-		os.Getenv("PACKAGE_LEVEL"),
-		os.Getenv("ACTION_LEVEL"),
-	))
-}
-
-func wrapHTML(body string) map[string]interface{} {
-	return map[string]interface{}{
-		"body": "<html><body><pre>" + string(body) + "</pre></body></html>",
+	vars := map[string]interface{}{
+		"PROJECT_LEVEL": os.Getenv("PROJECT_LEVEL"),
+		"PACKAGE_LEVEL": os.Getenv("PACKAGE_LEVEL"),
+		"ACTION_LEVEL":  os.Getenv("ACTION_LEVEL"),
 	}
+	return respond(args, http.StatusOK, fmt.Sprintf(
+		"PROJECT_LEVEL: %q\nPACKAGE_LEVEL: %q\nACTION_LEVEL: %q\n",
+		vars["PROJECT_LEVEL"],
+		vars["PACKAGE_LEVEL"],
+		vars["ACTION_LEVEL"],
+	), vars)
 }
@@ -0,0 +1,5 @@
+module github.com/jcodybaker/functions-load/tools
+
+go 1.17
+
+require gopkg.in/yaml.v2 v2.4.0
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/jcodybaker/functions-load/tools/project"
+)
+
+// launcherFile is the name of the generated file added to each action's build.
+const launcherFile = "zz_owlocal_launcher.go"
+
+// launcher is the main func compiled into each action. It speaks the Go runtime's action loop
+// protocol: one JSON activation per line on stdin, whose fields other than value are exported as
+// __OW_ environment variables, and one JSON result per line on fd 3, leaving stdout and stderr to
+// the action's logs.
+var launcher = template.Must(template.New("launcher").Parse(`// Code generated by owlocal. DO NOT EDIT.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+func main() {
+	out := os.NewFile(3, "result")
+	in := bufio.NewScanner(os.Stdin)
+	in.Buffer(make([]byte, 64<<10), 1<<30)
+	for in.Scan() {
+		var msg map[string]interface{}
+		var res interface{}
+		if err := json.Unmarshal(in.Bytes(), &msg); err != nil {
+			res = map[string]interface{}{"error": "parsing activation: " + err.Error()}
+		} else {
+			for k, v := range msg {
+				if s, ok := v.(string); ok && k != "value" {
+					os.Setenv("__OW_"+strings.ToUpper(k), s)
+				}
+			}
+			value, _ := msg["value"].(map[string]interface{})
+			res = {{.}}(value)
+		}
+		b, err := json.Marshal(res)
+		if err != nil {
+			b, _ = json.Marshal(map[string]interface{}{"error": "encoding result: " + err.Error()})
+		}
+		out.Write(append(b, '\n'))
+	}
+}
+`))
+
+// build assembles the action and compiles it with the launcher to a binary in outDir.
+func build(a *project.Action, outDir string) (string, error) {
+	src, err := assemble(a)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(src)
+
+	f, err := os.Create(filepath.Join(src, launcherFile))
+	if err != nil {
+		return "", err
+	}
+	err = launcher.Execute(f, a.Main)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	bin := filepath.Join(outDir, strings.ReplaceAll(a.Path(), "/", "-"))
+	cmd := goCommand(src, os.Environ(), "build", "-o", bin, ".")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("building %s: %w\n%s", a.Path(), err, out)
+	}
+	return bin, nil
+}
+
+// assemble copies the action and its .include files to a new temp dir, as the deployer does. The
+// caller removes it.
+func assemble(a *project.Action) (dir string, err error) {
+	src, err := os.MkdirTemp("", "owlocal-build-*")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(src)
+		}
+	}()
+
+	if err := copyTree(a.Dir, src); err != nil {
+		return "", err
+	}
+	includes, err := readInclude(filepath.Join(a.Dir, ".include"))
+	if err != nil {
+		return "", err
+	}
+	for _, inc := range includes {
+		p := filepath.Join(a.Dir, inc)
+		if err := copyTree(p, filepath.Join(src, filepath.Base(p))); err != nil {
+			return "", fmt.Errorf("including %s: %w", inc, err)
+		}
+	}
+	return src, nil
+}
+
+// goCommand returns a go command run in an assembled action. Actions without a go.mod are built
+// as the runtime builds them, outside module mode.
+func goCommand(dir string, env []string, args ...string) *exec.Cmd {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); os.IsNotExist(err) {
+		cmd.Env = append(cmd.Env, "GO111MODULE=off")
+	}
+	return cmd
+}
+
+// readInclude returns the paths listed in an .include file, or nothing if there isn't one.
+func readInclude(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var paths []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			paths = append(paths, line)
+		}
+	}
+	return paths, s.Err()
+}
+
+// copyTree copies the file or directory src to dst.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
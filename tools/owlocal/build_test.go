@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jcodybaker/functions-load/tools/project"
+)
+
+// TestNondefaultVars runs nondefault/vars with the environment resolved from project.yml, and
+// checks that it sees each level's variable.
+func TestNondefaultVars(t *testing.T) {
+	a := loadAction(t, "../../project.yml", "nondefault/vars")
+	res := invokeAction(t, a, map[string]interface{}{"format": "json"})
+	var got struct {
+		StatusCode int               `json:"statusCode"`
+		Body       map[string]string `json:"body"`
+	}
+	if err := json.Unmarshal(res, &got); err != nil {
+		t.Fatalf("decoding %s: %v", res, err)
+	}
+	want := map[string]string{
+		"PROJECT_LEVEL": "PROJECT_LEVEL",
+		"PACKAGE_LEVEL": "PACKAGE_LEVEL",
+		"ACTION_LEVEL":  "ACTION_LEVEL",
+	}
+	if got.StatusCode != 200 || !reflect.DeepEqual(got.Body, want) {
+		t.Errorf("got %s, want a 200 with %v", res, want)
+	}
+}
+
+// loadAction resolves the action at path in the project, without the repo's .env, which a
+// developer may have filled with anything.
+func loadAction(t *testing.T, projectPath, path string) *project.Action {
+	t.Helper()
+	envFile := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(envFile, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := project.LoadWithEnv(projectPath, envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions, err := p.Actions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions, err = selectActions(actions, []string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return actions[0]
+}
+
+// invokeAction builds a and runs one activation of it with params.
+func invokeAction(t *testing.T, a *project.Action, params map[string]interface{}) json.RawMessage {
+	t.Helper()
+	bin, err := build(a, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	act := &action{Action: a, bin: bin}
+	defer act.stop()
+	res, err := act.invoke(context.Background(), map[string]interface{}{"value": params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcodybaker/functions-load/tools/project"
+)
+
+// errExited means the action's process died during an activation, e.g. it panicked or exited.
+var errExited = errors.New("the action did not produce a valid response and exited unexpectedly")
+
+// action is a built action and its pool of warm instances.
+type action struct {
+	*project.Action
+	bin string
+
+	mu sync.Mutex
+	// initEnv is environment set by /init, over the project's.
+	initEnv map[string]string
+	idle    []*instance
+	// started counts instances, to name them in logs.
+	started int
+}
+
+// instance is one process running an action, which handles one activation at a time as the
+// platform's containers do.
+type instance struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	results *bufio.Reader
+}
+
+// invoke runs an activation on a warm instance, starting one if none is idle. The instance is
+// killed if the activation outlives the action's timeout or fails to respond, so the next
+// activation starts cold, as it would on the platform.
+func (a *action) invoke(ctx context.Context, activation map[string]interface{}) (json.RawMessage, error) {
+	inst, err := a.get()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := json.Marshal(activation)
+	if err != nil {
+		a.put(inst)
+		return nil, err
+	}
+
+	type result struct {
+		line []byte
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		if _, err := inst.stdin.Write(append(msg, '\n')); err != nil {
+			ch <- result{err: err}
+			return
+		}
+		line, err := inst.results.ReadBytes('\n')
+		ch <- result{line, err}
+	}()
+
+	timer := time.NewTimer(a.Timeout)
+	defer timer.Stop()
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			inst.kill()
+			return nil, errExited
+		}
+		a.put(inst)
+		return r.line, nil
+	case <-timer.C:
+		inst.kill()
+		return nil, fmt.Errorf("the action exceeded its time limit of %d milliseconds", a.Timeout.Milliseconds())
+	case <-ctx.Done():
+		inst.kill()
+		return nil, ctx.Err()
+	}
+}
+
+func (a *action) get() (*instance, error) {
+	a.mu.Lock()
+	if n := len(a.idle); n > 0 {
+		inst := a.idle[n-1]
+		a.idle = a.idle[:n-1]
+		a.mu.Unlock()
+		return inst, nil
+	}
+	a.started++
+	name := fmt.Sprintf("%s#%d", a.Path(), a.started)
+	env := a.environ()
+	a.mu.Unlock()
+
+	log.Printf("%s: cold start", name)
+	return startInstance(a.bin, name, env)
+}
+
+func (a *action) put(inst *instance) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.idle = append(a.idle, inst)
+}
+
+// setInitEnv replaces the environment set by /init. Idle instances are stopped so the next
+// activation starts with it.
+func (a *action) setInitEnv(env map[string]string) {
+	a.mu.Lock()
+	a.initEnv = env
+	a.mu.Unlock()
+	a.stop()
+}
+
+// stop kills the idle instances. Busy ones are killed when their activation ends or times out.
+func (a *action) stop() {
+	a.mu.Lock()
+	idle := a.idle
+	a.idle = nil
+	a.mu.Unlock()
+	for _, inst := range idle {
+		inst.kill()
+	}
+}
+
+// environ returns the environment for a new instance: ours, then the project's, then /init's.
+// Must be called with mu held.
+func (a *action) environ() []string {
+	return withEnv(os.Environ(), a.Env, a.initEnv)
+}
+
+// withEnv appends the variables of each map to env, in order, so later ones win.
+func withEnv(env []string, maps ...map[string]string) []string {
+	for _, m := range maps {
+		for _, k := range sortedKeys(m) {
+			env = append(env, k+"="+m[k])
+		}
+	}
+	return env
+}
+
+// startInstance starts bin with its result pipe on fd 3 and its logs prefixed by name.
+func startInstance(bin, name string, env []string) (*instance, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(bin)
+	cmd.Env = env
+	cmd.ExtraFiles = []*os.File{w}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return nil, fmt.Errorf("starting %s: %w", name, err)
+	}
+	// Only the child writes results; holding our copy would hide its exit from the reader.
+	w.Close()
+	go func() {
+		// Lines are read whole however long they are. A Scanner would give up on one longer than
+		// its buffer, leaving the child blocked on a full pipe.
+		logs := bufio.NewReader(stdout)
+		for {
+			line, err := logs.ReadString('\n')
+			if line != "" {
+				log.Printf("%s: %s", name, strings.TrimSuffix(line, "\n"))
+			}
+			if err != nil {
+				break
+			}
+		}
+		err := cmd.Wait()
+		r.Close()
+		log.Printf("%s: exited: %v", name, err)
+	}()
+	return &instance{cmd: cmd, stdin: stdin, results: bufio.NewReader(r)}, nil
+}
+
+func (inst *instance) kill() {
+	inst.cmd.Process.Kill()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLongLogLine invokes an action which logs a line longer than any pipe or scanner buffer, and
+// checks it isn't left blocked writing it.
+func TestLongLogLine(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"project.yml": `
+packages:
+  - name: test
+    actions:
+      - name: log
+        runtime: go:default
+`,
+		"packages/test/log/main.go": `package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func Main(args map[string]interface{}) map[string]interface{} {
+	fmt.Println(strings.Repeat("x", 1<<20))
+	return map[string]interface{}{"body": "logged"}
+}
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The line is a megabyte, which isn't worth printing.
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	a := loadAction(t, filepath.Join(dir, "project.yml"), "test/log")
+	if res := invokeAction(t, a, nil); string(res) != `{"body":"logged"}`+"\n" {
+		t.Errorf("got %s", res)
+	}
+}
@@ -0,0 +1,186 @@
+// Command owlocal runs the project's actions locally behind the OpenWhisk runtime protocol, so
+// they can be exercised, and the load tools pointed at them, without deploying.
+//
+// Each action in packages/ is assembled with its .include files and compiled with a launcher
+// calling its Main, as the Go runtime does. Activations run in a pool of instance processes, one
+// activation per instance at a time, so warm reuse, cold starts and instance-level state behave
+// as on the platform. Instances get the project.yml environment and the __OW_ activation
+// variables, activations get the project.yml parameters as defaults, and instances are killed if
+// they outlive the action's limits.timeout (default 3s).
+//
+//	go run ./owlocal -project ../project.yml [-addr localhost:8080] [package/action ...]
+//
+// ${VAR}s in project.yml are resolved from -env-file, by default the .env beside project.yml,
+// then the process environment. -print-env prints what each action would get and exits.
+//
+// -test runs go test in each action with tests, which can't be run in place as the package is
+// only complete with its .include files, and then in the directories they include files from,
+// such as lib/:
+//
+//	go run ./owlocal -project ../project.yml -test -test-flags "-run Migrate -v" load/concurrency
+//
+// Each action is served at
+//
+//	POST /<package>/<action>/init       the runtime's /init; only value.env is honoured
+//	POST /<package>/<action>/run        the runtime's /run
+//	     /api/v1/web/<ns>/<package>/<action>[/path]   as a web action, honouring web: raw
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/jcodybaker/functions-load/tools/project"
+)
+
+func main() {
+	projectPath := flag.String("project", "project.yml", "path to the project.yml whose packages are served")
+	envFile := flag.String("env-file", "", "file of variables for project.yml, before the process environment (default .env beside project.yml)")
+	printEnv := flag.Bool("print-env", false, "print the resolved environment and parameters of the actions and exit")
+	test := flag.Bool("test", false, "run the actions' tests, assembled with their includes and environment, and exit")
+	testFlags := flag.String("test-flags", "", "space separated flags for go test, e.g. \"-run Migrate -v\"")
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	namespace := flag.String("namespace", "local", "namespace reported to the actions and expected in web action URLs")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [package/action ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var p *project.Project
+	var err error
+	if *envFile == "" {
+		p, err = project.Load(*projectPath)
+	} else {
+		p, err = project.LoadWithEnv(*projectPath, *envFile)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	actions, err := p.Actions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if actions, err = selectActions(actions, flag.Args()); err != nil {
+		log.Fatal(err)
+	}
+	switch {
+	case *printEnv:
+		err = printActions(os.Stdout, actions)
+	case *test:
+		err = runTests(actions, strings.Fields(*testFlags))
+	default:
+		err = serve(actions, *addr, *namespace)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serve(actions []*project.Action, addr, namespace string) error {
+	binDir, err := os.MkdirTemp("", "owlocal-bin-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(binDir)
+
+	s := &server{
+		namespace: namespace,
+		apiHost:   "http://" + addr,
+		actions:   map[string]*action{},
+	}
+	for _, a := range actions {
+		log.Printf("building %s", a.Path())
+		bin, err := build(a, binDir)
+		if err != nil {
+			return err
+		}
+		s.actions[a.Path()] = &action{Action: a, bin: bin}
+	}
+	defer func() {
+		for _, a := range s.actions {
+			a.stop()
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Addr: addr, Handler: s}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	for _, a := range actions {
+		if a.Web {
+			log.Printf("serving %s at http://%s/api/v1/web/%s/%s", a.Path(), addr, namespace, a.Path())
+		} else {
+			log.Printf("serving %s at http://%s/%s/run", a.Path(), addr, a.Path())
+		}
+	}
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return srv.Shutdown(context.Background())
+	}
+}
+
+// selectActions returns the named actions, or all of them if none are named.
+func selectActions(actions []*project.Action, only []string) ([]*project.Action, error) {
+	if len(only) == 0 {
+		return actions, nil
+	}
+	byPath := map[string]*project.Action{}
+	for _, a := range actions {
+		byPath[a.Path()] = a
+	}
+	var selected []*project.Action
+	for _, name := range only {
+		a, ok := byPath[strings.Trim(name, "/")]
+		if !ok {
+			return nil, fmt.Errorf("no action %q in the project", name)
+		}
+		selected = append(selected, a)
+	}
+	return selected, nil
+}
+
+// printActions writes each action's environment and parameters, as KEY=value lines sorted by key.
+func printActions(w io.Writer, actions []*project.Action) error {
+	for i, a := range actions {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "# %s\n", a.Path())
+		for _, k := range sortedKeys(a.Env) {
+			fmt.Fprintf(w, "%s=%s\n", k, a.Env[k])
+		}
+		if len(a.Params) == 0 {
+			continue
+		}
+		params, err := json.MarshalIndent(a.Params, "", "  ")
+		if err != nil {
+			return fmt.Errorf("%s: encoding parameters: %w", a.Path(), err)
+		}
+		fmt.Fprintf(w, "# parameters: %s\n", params)
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jcodybaker/functions-load/tools/project"
+)
+
+// runTests runs go test in each action which has tests, assembled with its .include files as it
+// is for deployment and with its project.yml environment, so tests against a database pick up
+// DATABASE_URL from .env. Then it tests the directories the actions include files from, such as
+// lib/, in place. Each is tested whatever happened to the ones before.
+func runTests(actions []*project.Action, flags []string) error {
+	var failed []string
+	for _, a := range actions {
+		if !hasTests(a.Dir) {
+			continue
+		}
+		log.Printf("testing %s", a.Path())
+		if err := testAction(a, flags); err != nil {
+			log.Printf("%s: %v", a.Path(), err)
+			failed = append(failed, a.Path())
+		}
+	}
+	dirs, err := includedDirs(actions)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if !hasTests(dir) {
+			continue
+		}
+		log.Printf("testing %s", dir)
+		if err := testDir(dir, os.Environ(), flags); err != nil {
+			log.Printf("%s: %v", dir, err)
+			failed = append(failed, dir)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("tests failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func testAction(a *project.Action, flags []string) error {
+	dir, err := assemble(a)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	return testDir(dir, withEnv(os.Environ(), a.Env), flags)
+}
+
+func testDir(dir string, env, flags []string) error {
+	cmd := goCommand(dir, env, append(append([]string{"test"}, flags...), ".")...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func hasTests(dir string) bool {
+	tests, _ := filepath.Glob(filepath.Join(dir, "*_test.go"))
+	return len(tests) > 0
+}
+
+// includedDirs returns the directories of the files the actions' .include files list, once each.
+// Those files are package main for every action, so each directory builds as one package, outside
+// module mode as the actions do. Included directories are packages of their own within the
+// action, and aren't tested.
+func includedDirs(actions []*project.Action) ([]string, error) {
+	var dirs []string
+	seen := map[string]bool{}
+	for _, a := range actions {
+		includes, err := readInclude(filepath.Join(a.Dir, ".include"))
+		if err != nil {
+			return nil, err
+		}
+		for _, inc := range includes {
+			p := filepath.Join(a.Dir, inc)
+			if fi, err := os.Stat(p); err != nil || fi.IsDir() {
+				continue
+			}
+			if dir := filepath.Dir(p); !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs, nil
+}
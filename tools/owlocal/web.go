@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBody bounds request bodies, a little above the platform's own 1MiB parameter limit so that
+// limit can be exceeded locally too.
+const maxBody = 4 << 20
+
+// server routes requests to the actions.
+type server struct {
+	namespace string
+	apiHost   string
+	actions   map[string]*action
+}
+
+// ServeHTTP serves the runtime protocol for each action at /<package>/<action>/init and /run,
+// and the action as a web action at /api/v1/web/<namespace>/<package>/<action>[/path].
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rest := strings.TrimPrefix(r.URL.Path, "/api/v1/web/"); rest != r.URL.Path {
+		parts := strings.SplitN(rest, "/", 4)
+		if len(parts) < 3 || (parts[0] != s.namespace && parts[0] != "_") {
+			httpError(w, http.StatusNotFound, "The requested resource does not exist.")
+			return
+		}
+		path := ""
+		if len(parts) == 4 {
+			path = "/" + parts[3]
+		}
+		s.web(w, r, parts[1]+"/"+strings.TrimSuffix(parts[2], ".http"), path)
+		return
+	}
+
+	i := strings.LastIndex(r.URL.Path, "/")
+	a, ok := s.actions[strings.Trim(r.URL.Path[:i], "/")]
+	if !ok {
+		httpError(w, http.StatusNotFound, "The requested resource does not exist.")
+		return
+	}
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, "Only POST is supported.")
+		return
+	}
+	switch r.URL.Path[i+1:] {
+	case "init":
+		s.init(w, r, a)
+	case "run":
+		s.run(w, r, a)
+	default:
+		httpError(w, http.StatusNotFound, "The requested resource does not exist.")
+	}
+}
+
+// init handles the runtime's /init. The code was built from the tree at startup, so only the
+// environment in the request is used.
+func (s *server) init(w http.ResponseWriter, r *http.Request, a *action) {
+	var req struct {
+		Value struct {
+			Env map[string]interface{} `json:"env"`
+		} `json:"value"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBody)).Decode(&req); err != nil && err != io.EOF {
+		httpError(w, http.StatusBadRequest, fmt.Sprintf("parsing init: %v", err))
+		return
+	}
+	env := map[string]string{}
+	for k, v := range req.Value.Env {
+		if s, ok := v.(string); ok {
+			env[k] = s
+		} else {
+			env[k] = fmt.Sprint(v)
+		}
+	}
+	a.setInitEnv(env)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true})
+}
+
+// run handles the runtime's /run, filling in the activation fields the controller would send.
+func (s *server) run(w http.ResponseWriter, r *http.Request, a *action) {
+	var activation map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBody)).Decode(&activation); err != nil {
+		httpError(w, http.StatusBadRequest, fmt.Sprintf("parsing activation: %v", err))
+		return
+	}
+	if _, ok := activation["value"].(map[string]interface{}); !ok {
+		activation["value"] = map[string]interface{}{}
+	}
+	s.fillActivation(activation, a)
+	res, err := a.invoke(r.Context(), activation)
+	if err != nil {
+		httpError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// web invokes the action as a web action, translating the request into __ow_ params and the
+// result's statusCode, headers and body into the response.
+func (s *server) web(w http.ResponseWriter, r *http.Request, name, path string) {
+	a, ok := s.actions[name]
+	if !ok || !a.Web {
+		httpError(w, http.StatusNotFound, "The requested resource does not exist.")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, fmt.Sprintf("reading body: %v", err))
+		return
+	}
+	if len(body) > maxBody {
+		httpError(w, http.StatusRequestEntityTooLarge, "The request content was malformed: request entity too large.")
+		return
+	}
+	params, err := webParams(r, path, body, a.Raw)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	activation := map[string]interface{}{"value": params}
+	s.fillActivation(activation, a)
+	res, err := a.invoke(r.Context(), activation)
+	if err != nil {
+		httpError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeResult(w, res)
+}
+
+// fillActivation adds the action's default params to the activation's value, and sets the
+// activation fields which the launcher exports as __OW_ variables, unless the caller set them.
+func (s *server) fillActivation(activation map[string]interface{}, a *action) {
+	value := activation["value"].(map[string]interface{})
+	for k, v := range a.Params {
+		if _, ok := value[k]; !ok {
+			value[k] = v
+		}
+	}
+	deadline := time.Now().Add(a.Timeout)
+	for k, v := range map[string]string{
+		"activation_id":  newActivationID(),
+		"action_name":    "/" + s.namespace + "/" + a.Path(),
+		"namespace":      s.namespace,
+		"api_host":       s.apiHost,
+		"deadline":       strconv.FormatInt(deadline.UnixNano()/int64(time.Millisecond), 10),
+		"transaction_id": newActivationID(),
+	} {
+		if _, ok := activation[k]; !ok {
+			activation[k] = v
+		}
+	}
+}
+
+// webParams builds a web action's params. Raw actions receive the query string and body as is;
+// others have the query and a JSON or form body merged into their params, with any other body in
+// __ow_body. Bodies which aren't textual are base64 encoded.
+func webParams(r *http.Request, path string, body []byte, raw bool) (map[string]interface{}, error) {
+	headers := map[string]interface{}{}
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ", ")
+	}
+	if r.Host != "" {
+		headers["host"] = r.Host
+	}
+	params := map[string]interface{}{
+		"__ow_method":  strings.ToLower(r.Method),
+		"__ow_headers": headers,
+		"__ow_path":    path,
+	}
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	if raw {
+		params["__ow_query"] = r.URL.RawQuery
+		if len(body) > 0 {
+			params["__ow_body"] = encodeBody(body, contentType)
+		}
+		return params, nil
+	}
+
+	for k, v := range r.URL.Query() {
+		params[k] = v[len(v)-1]
+	}
+	if len(body) == 0 {
+		return params, nil
+	}
+	switch {
+	case mediaType == "application/json":
+		var obj map[string]interface{}
+		if err := json.Unmarshal(body, &obj); err != nil {
+			return nil, fmt.Errorf("the request content was malformed: %v", err)
+		}
+		for k, v := range obj {
+			params[k] = v
+		}
+	case mediaType == "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("the request content was malformed: %v", err)
+		}
+		for k, v := range form {
+			params[k] = v[len(v)-1]
+		}
+	default:
+		params["__ow_body"] = encodeBody(body, contentType)
+	}
+	return params, nil
+}
+
+// writeResult writes a web action's result. Like the platform, it treats a result with none of
+// statusCode, headers and body as the JSON body, and decodes string bodies whose Content-Type
+// isn't textual from base64.
+func writeResult(w http.ResponseWriter, res json.RawMessage) {
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(res, &result); err != nil {
+		httpError(w, http.StatusBadGateway, fmt.Sprintf("The action did not produce a valid JSON response: %v", err))
+		return
+	}
+	_, hasStatus := result["statusCode"]
+	_, hasHeaders := result["headers"]
+	rawBody, hasBody := result["body"]
+	if !hasStatus && !hasHeaders && !hasBody {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(res)
+		return
+	}
+
+	status := http.StatusOK
+	if hasStatus {
+		if err := json.Unmarshal(result["statusCode"], &status); err != nil {
+			httpError(w, http.StatusBadGateway, "The action did not produce a valid response: statusCode isn't a number.")
+			return
+		}
+	}
+	var headers map[string]interface{}
+	if hasHeaders {
+		if err := json.Unmarshal(result["headers"], &headers); err != nil {
+			httpError(w, http.StatusBadGateway, "The action did not produce a valid response: headers isn't an object.")
+			return
+		}
+	}
+	for k, v := range headers {
+		w.Header().Set(k, fmt.Sprint(v))
+	}
+
+	var out []byte
+	var s string
+	switch {
+	case !hasBody || string(rawBody) == "null":
+		if !hasStatus {
+			status = http.StatusNoContent
+		}
+	case json.Unmarshal(rawBody, &s) == nil:
+		out = []byte(s)
+		if !isTextual(w.Header().Get("Content-Type")) {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				httpError(w, http.StatusBadGateway, "The action did not produce a valid response: a binary body must be base64 encoded.")
+				return
+			}
+			out = b
+		}
+	default:
+		out = rawBody
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/json")
+		}
+	}
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+func encodeBody(body []byte, contentType string) string {
+	if isTextual(contentType) {
+		return string(body)
+	}
+	return base64.StdEncoding.EncodeToString(body)
+}
+
+// isTextual reports whether the platform passes bodies of contentType through as text.
+func isTextual(contentType string) bool {
+	ct := strings.ToLower(contentType)
+	return ct == "" ||
+		strings.HasPrefix(ct, "text/") ||
+		strings.Contains(ct, "json") ||
+		strings.Contains(ct, "xml") ||
+		strings.HasPrefix(ct, "application/x-www-form-urlencoded")
+}
+
+func httpError(w http.ResponseWriter, status int, msg string) {
+	if status >= http.StatusInternalServerError {
+		log.Printf("%d: %s", status, msg)
+	}
+	writeJSON(w, status, map[string]interface{}{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// newActivationID returns 32 random hex digits, the form of the platform's activation IDs.
+func newActivationID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,230 @@
+// Package project reads a functions project.yml and resolves each action's settings as the
+// deployer does: environment and parameters are inherited from the project to its packages and
+// from a package to its actions, the most specific level winning, and ${VAR} and $VAR in their
+// values are substituted from a .env file or the process environment.
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultTimeout is the platform's time limit for actions which don't set limits.timeout.
+const DefaultTimeout = 3 * time.Second
+
+// Project is a parsed project.yml, before variables are substituted.
+type Project struct {
+	// Dir is the directory holding project.yml, whose packages directory holds the actions.
+	Dir string `yaml:"-"`
+	// Vars are the variables from the .env file, which are looked up before the process's.
+	Vars map[string]string `yaml:"-"`
+
+	Environment map[string]string      `yaml:"environment"`
+	Parameters  map[string]interface{} `yaml:"parameters"`
+	Packages    []Package              `yaml:"packages"`
+}
+
+// Package is a package in project.yml.
+type Package struct {
+	Name        string                 `yaml:"name"`
+	Environment map[string]string      `yaml:"environment"`
+	Parameters  map[string]interface{} `yaml:"parameters"`
+	Actions     []ActionSpec           `yaml:"actions"`
+}
+
+// ActionSpec is an action in project.yml.
+type ActionSpec struct {
+	Name        string                 `yaml:"name"`
+	Main        string                 `yaml:"main"`
+	Web         interface{}            `yaml:"web"`
+	Environment map[string]string      `yaml:"environment"`
+	Parameters  map[string]interface{} `yaml:"parameters"`
+	Limits      struct {
+		// Timeout is in milliseconds.
+		Timeout int `yaml:"timeout"`
+	} `yaml:"limits"`
+}
+
+// Action is an action's resolved settings.
+type Action struct {
+	Package string
+	Name    string
+	// Dir is the action's source directory.
+	Dir string
+	// Main is the function which handles activations.
+	Main string
+	// Env is the action's environment, after the project, package and action levels are merged.
+	Env map[string]string
+	// Params are the action's default parameters, merged like Env.
+	Params  map[string]interface{}
+	Timeout time.Duration
+	// Web is whether the action is a web action, and Raw whether it receives raw HTTP requests.
+	Web, Raw bool
+}
+
+// Path returns the action's package/action name.
+func (a *Action) Path() string {
+	return a.Package + "/" + a.Name
+}
+
+// Load reads the project.yml at path, and the .env file beside it if there is one.
+func Load(path string) (*Project, error) {
+	return LoadWithEnv(path, filepath.Join(filepath.Dir(path), ".env"))
+}
+
+// LoadWithEnv reads the project.yml at path and takes variables from envFile. A missing envFile is
+// only an error if it isn't the default .env.
+func LoadWithEnv(path, envFile string) (*Project, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Project{Dir: filepath.Dir(path)}
+	if err := yaml.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	p.Vars, err = ReadEnvFile(envFile)
+	if os.IsNotExist(err) && envFile == filepath.Join(p.Dir, ".env") {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Lookup returns a variable from the .env file or, failing that, the process environment.
+func (p *Project) Lookup(name string) (string, bool) {
+	if v, ok := p.Vars[name]; ok {
+		return v, true
+	}
+	return os.LookupEnv(name)
+}
+
+// Expand substitutes ${VAR} and $VAR in s. Unset variables become empty, as in a shell.
+func (p *Project) Expand(s string) string {
+	return os.Expand(s, func(name string) string {
+		v, _ := p.Lookup(name)
+		return v
+	})
+}
+
+// Actions returns every action in the packages directory, sorted by path. As with the deployer,
+// actions needn't be declared in project.yml; those which are take their settings from it.
+func (p *Project) Actions() ([]*Action, error) {
+	dirs, err := filepath.Glob(filepath.Join(p.Dir, "packages", "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	var actions []*Action
+	for _, dir := range dirs {
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			continue
+		}
+		a, err := p.Action(filepath.Base(filepath.Dir(dir)), filepath.Base(dir))
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i].Path() < actions[j].Path() })
+	return actions, nil
+}
+
+// Action resolves the settings of the named action.
+func (p *Project) Action(pkgName, name string) (*Action, error) {
+	a := &Action{
+		Package: pkgName,
+		Name:    name,
+		Dir:     filepath.Join(p.Dir, "packages", pkgName, name),
+		Main:    "Main",
+		Env:     map[string]string{},
+		Params:  map[string]interface{}{},
+		Timeout: DefaultTimeout,
+		Web:     true,
+	}
+	p.merge(a, p.Environment, p.Parameters)
+	for _, pkg := range p.Packages {
+		if pkg.Name != pkgName {
+			continue
+		}
+		p.merge(a, pkg.Environment, pkg.Parameters)
+		for _, spec := range pkg.Actions {
+			if spec.Name != name {
+				continue
+			}
+			if err := p.apply(a, spec); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return a, nil
+}
+
+// apply overlays an action's project.yml settings.
+func (p *Project) apply(a *Action, spec ActionSpec) error {
+	p.merge(a, spec.Environment, spec.Parameters)
+	if spec.Main != "" {
+		a.Main = spec.Main
+	}
+	if spec.Limits.Timeout > 0 {
+		a.Timeout = time.Duration(spec.Limits.Timeout) * time.Millisecond
+	}
+	switch w := spec.Web.(type) {
+	case nil:
+	case bool:
+		a.Web = w
+	case string:
+		switch strings.ToLower(w) {
+		case "raw":
+			a.Raw = true
+		case "true", "yes":
+		case "false", "no":
+			a.Web = false
+		default:
+			return fmt.Errorf("%s: unknown web setting %q", a.Path(), w)
+		}
+	default:
+		return fmt.Errorf("%s: unknown web setting %v", a.Path(), w)
+	}
+	return nil
+}
+
+// merge overlays one level's environment and parameters, substituting variables.
+func (p *Project) merge(a *Action, env map[string]string, params map[string]interface{}) {
+	for k, v := range env {
+		a.Env[k] = p.Expand(v)
+	}
+	for k, v := range params {
+		a.Params[k] = p.jsonValue(v)
+	}
+}
+
+// jsonValue substitutes variables in a parameter's strings, and converts the
+// map[interface{}]interface{} which yaml decodes nested maps to into something encoding/json
+// accepts.
+func (p *Project) jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return p.Expand(v)
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = p.jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = p.jsonValue(e)
+		}
+		return l
+	}
+	return v
+}
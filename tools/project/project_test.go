@@ -0,0 +1,220 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNondefaultVars(t *testing.T) {
+	p, err := LoadWithEnv("../../project.yml", filepath.Join(t.TempDir(), "none.env"))
+	if err == nil {
+		t.Fatal("want an error for a missing -env-file")
+	}
+	if p, err = LoadWithEnv("../../project.yml", emptyEnvFile(t)); err != nil {
+		t.Fatal(err)
+	}
+	a, err := p.Action("nondefault", "vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"PROJECT_LEVEL": "PROJECT_LEVEL",
+		"PACKAGE_LEVEL": "PACKAGE_LEVEL",
+		"ACTION_LEVEL":  "ACTION_LEVEL",
+	}
+	if !reflect.DeepEqual(a.Env, want) {
+		t.Errorf("env = %v, want %v", a.Env, want)
+	}
+	if a.Main != "Main" || !a.Web || a.Raw || a.Timeout != DefaultTimeout {
+		t.Errorf("got main=%s web=%t raw=%t timeout=%s, want the defaults", a.Main, a.Web, a.Raw, a.Timeout)
+	}
+
+	actions, err := p.Actions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, a := range actions {
+		if a.Path() == "nondefault/vars" {
+			found = reflect.DeepEqual(a.Env, want)
+		}
+		if a.Package == "logs" && a.Env["PACKAGE_LEVEL"] != "" {
+			t.Errorf("%s inherited nondefault's PACKAGE_LEVEL", a.Path())
+		}
+	}
+	if !found {
+		t.Error("Actions didn't return nondefault/vars with its environment")
+	}
+}
+
+func TestDatabaseURL(t *testing.T) {
+	// The repo's own .env, if a developer has one, would win over the process, so it's avoided.
+	t.Setenv("DATABASE_URL", "postgres://from-process")
+	p, err := LoadWithEnv("../../project.yml", emptyEnvFile(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := p.Action("load", "concurrency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Env["DATABASE_URL"]; got != "postgres://from-process" {
+		t.Errorf("DATABASE_URL = %q, want the process's", got)
+	}
+
+	envFile := filepath.Join(t.TempDir(), ".env")
+	writeFile(t, envFile, "DATABASE_URL=sqlite:/tmp/from-env-file.db\n")
+	if p, err = LoadWithEnv("../../project.yml", envFile); err != nil {
+		t.Fatal(err)
+	}
+	if a, err = p.Action("load", "concurrency"); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Env["DATABASE_URL"]; got != "sqlite:/tmp/from-env-file.db" {
+		t.Errorf("DATABASE_URL = %q, want the .env file's over the process's", got)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "project.yml"), `
+environment:
+  LEVEL: project
+  FROM_PROJECT: "${NAME}-$NAME"
+  UNSET: "[${OWLOCAL_TEST_UNSET}]"
+parameters:
+  level: project
+  nested: {name: "${NAME}", list: ["$NAME", 1]}
+packages:
+  - name: pkg
+    environment:
+      LEVEL: package
+      FROM_PACKAGE: package
+    parameters:
+      level: package
+    actions:
+      - name: action
+        main: Handle
+        web: raw
+        limits:
+          timeout: 1500
+        environment:
+          LEVEL: action
+      - name: hidden
+        web: false
+  - name: other
+    environment:
+      FROM_OTHER: other
+`)
+	writeFile(t, filepath.Join(dir, ".env"), "NAME=dotenv\n")
+	for _, d := range []string{"pkg/action", "pkg/hidden", "pkg/undeclared", "other/action"} {
+		if err := os.MkdirAll(filepath.Join(dir, "packages", d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// t.Setenv restores the variable when the test ends, so unsetting it doesn't leak.
+	t.Setenv("OWLOCAL_TEST_UNSET", "")
+	os.Unsetenv("OWLOCAL_TEST_UNSET")
+	p, err := Load(filepath.Join(dir, "project.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions, err := p.Actions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	byPath := map[string]*Action{}
+	for _, a := range actions {
+		paths = append(paths, a.Path())
+		byPath[a.Path()] = a
+	}
+	if want := []string{"other/action", "pkg/action", "pkg/hidden", "pkg/undeclared"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("actions = %v, want %v", paths, want)
+	}
+
+	a := byPath["pkg/action"]
+	wantEnv := map[string]string{
+		"LEVEL":        "action",
+		"FROM_PROJECT": "dotenv-dotenv",
+		"FROM_PACKAGE": "package",
+		"UNSET":        "[]",
+	}
+	if !reflect.DeepEqual(a.Env, wantEnv) {
+		t.Errorf("env = %v, want %v", a.Env, wantEnv)
+	}
+	wantParams := map[string]interface{}{
+		"level":  "package",
+		"nested": map[string]interface{}{"name": "dotenv", "list": []interface{}{"dotenv", 1}},
+	}
+	if !reflect.DeepEqual(a.Params, wantParams) {
+		t.Errorf("params = %#v, want %#v", a.Params, wantParams)
+	}
+	if a.Main != "Handle" || !a.Web || !a.Raw || a.Timeout != 1500*time.Millisecond {
+		t.Errorf("got main=%s web=%t raw=%t timeout=%s", a.Main, a.Web, a.Raw, a.Timeout)
+	}
+	if byPath["pkg/hidden"].Web {
+		t.Error("pkg/hidden is a web action despite web: false")
+	}
+	if got := byPath["pkg/undeclared"].Env["LEVEL"]; got != "package" {
+		t.Errorf("undeclared action's LEVEL = %q, want the package's", got)
+	}
+	if got := byPath["other/action"].Env; got["LEVEL"] != "project" || got["FROM_PACKAGE"] != "" || got["FROM_OTHER"] != "other" {
+		t.Errorf("other/action's env = %v", got)
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	writeFile(t, path, `
+# comment
+PLAIN=value
+SPACED = padded  
+export EXPORTED=yes
+SINGLE='lit $HOME \n "x"'
+DOUBLE="tab\there \"q\""
+EMPTY=
+EQUALS=a=b
+`)
+	got, err := ReadEnvFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"PLAIN":    "value",
+		"SPACED":   "padded",
+		"EXPORTED": "yes",
+		"SINGLE":   `lit $HOME \n "x"`,
+		"DOUBLE":   "tab\there \"q\"",
+		"EMPTY":    "",
+		"EQUALS":   "a=b",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"NOEQUALS\n", "=value\n", `BAD="unterminated \"` + "\n"} {
+		writeFile(t, path, bad)
+		if _, err := ReadEnvFile(path); err == nil {
+			t.Errorf("want an error for %q", bad)
+		}
+	}
+}
+
+// emptyEnvFile returns an .env file setting nothing.
+func emptyEnvFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ".env")
+	writeFile(t, path, "")
+	return path
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}